@@ -0,0 +1,177 @@
+// Package bot builds chat bots on top of client.Client: bots register
+// commands, patterns and scheduled tasks, and the package takes care of the
+// packet stream and of reconnecting to the server.
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/root-man/chat/client"
+	"github.com/root-man/chat/logging"
+	"github.com/root-man/chat/packets"
+)
+
+// systemUser is the sender name the server uses for itself.
+const systemUser = "CHAT"
+
+var logger = logging.For("bot")
+
+const (
+	// minReconnectDelay is the wait before reconnecting, doubling with each
+	// failed attempt up to maxReconnectDelay.
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+// Config holds the settings of a bot, as read from its JSON file.
+type Config struct {
+	// Name is the username of the bot.
+	Name string `json:"name"`
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	// Prefix starts the commands addressed to the bot, "!" by default.
+	Prefix string `json:"prefix"`
+	// StateFile is where the state of the bot is persisted. The state is kept
+	// in memory only when it is empty.
+	StateFile string `json:"state-file"`
+}
+
+// LoadConfig reads the configuration of a bot from a JSON file.
+func LoadConfig(path string) (Config, error) {
+	config := Config{Host: "localhost", Port: 4444, Prefix: "!"}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("invalid bot configuration %s: %w", path, err)
+	}
+	if config.Name == "" {
+		return config, fmt.Errorf("invalid bot configuration %s: the name is required", path)
+	}
+
+	return config, nil
+}
+
+// Bot is a chat user driven by handlers rather than by a person.
+type Bot struct {
+	config   Config
+	client   *client.Client
+	state    *State
+	commands map[string]*command
+	patterns []*pattern
+	tasks    []*task
+	// lastID is the ID of the last message seen, so that the messages missed
+	// while disconnected are handled once reconnected.
+	lastID         uint64
+	reconnectDelay time.Duration
+}
+
+// New creates a bot, loading its state. It comes with a help command listing
+// the other ones.
+func New(config Config) (*Bot, error) {
+	if config.Prefix == "" {
+		config.Prefix = "!"
+	}
+
+	state, err := openState(config.StateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bot{config: config, client: client.New(config.Name), state: state, commands: make(map[string]*command), reconnectDelay: minReconnectDelay}
+	b.Command("help", "help", "list the commands", b.help)
+	return b, nil
+}
+
+// Name returns the username the bot is currently known by.
+func (b *Bot) Name() string {
+	return b.client.Name()
+}
+
+// Client gives access to the underlying client, e.g. to react to messages.
+func (b *Bot) Client() *client.Client {
+	return b.client
+}
+
+// State returns the memory of the bot.
+func (b *Bot) State() *State {
+	return b.state
+}
+
+// Send posts a message.
+func (b *Bot) Send(text string) error {
+	_, err := b.client.Send(text)
+	return err
+}
+
+// Run connects the bot and handles messages until ctx is done, reconnecting
+// whenever the connection is lost.
+func (b *Bot) Run(ctx context.Context) error {
+	for _, t := range b.tasks {
+		go t.start(ctx)
+	}
+
+	delay := b.reconnectDelay
+	for {
+		received, err := b.client.Connect(b.config.Host, b.config.Port)
+		if err == nil {
+			logger.Info("Bot connected", "bot", b.Name(), "host", b.config.Host, "port", b.config.Port)
+			delay = b.reconnectDelay
+			b.serve(ctx, received)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		logger.Warn("Bot disconnected, reconnecting", "bot", b.Name(), "delay", delay)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// serve handles the packets of a connection until it is closed.
+func (b *Bot) serve(ctx context.Context, received <-chan packets.Packet) {
+	// The server replays recent messages on connection, which are only new to
+	// a bot that was connected before
+	replaying := true
+	for {
+		select {
+		case <-ctx.Done():
+			b.client.Close()
+			for range received {
+			}
+			return
+		case p, ok := <-received:
+			if !ok {
+				return
+			}
+
+			switch p := p.(type) {
+			case *packets.ReadReceipt:
+				// The welcome ends with the bot's own read position
+				if p.Username == b.Name() {
+					replaying = false
+				}
+			case *packets.Message:
+				seen := b.lastID
+				b.lastID = max(b.lastID, p.ID)
+				if replaying && (seen == 0 || p.ID <= seen) {
+					continue
+				}
+				if p.From != systemUser && p.From != b.Name() {
+					b.handle(p)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package bot
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/root-man/chat/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServer accepts the bot's connections, one at a time.
+type fakeServer struct {
+	t        *testing.T
+	listener net.Listener
+	conn     net.Conn
+}
+
+func (s *fakeServer) accept(welcome ...packets.Packet) {
+	conn, err := s.listener.Accept()
+	require.NoError(s.t, err)
+	s.conn = conn
+
+	handshake := s.read().(*packets.Handshake)
+	s.write(&packets.HandshakeResponse{Version: packets.ProtocolVersion})
+	for _, p := range welcome {
+		s.write(p)
+	}
+	s.write(&packets.ReadReceipt{Username: handshake.Username})
+}
+
+func (s *fakeServer) write(p packets.Packet) {
+	_, err := s.conn.Write(packets.Frame(p))
+	require.NoError(s.t, err)
+}
+
+func (s *fakeServer) read() packets.Packet {
+	// Longer than the tick of the reminders
+	s.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	p, err := packets.ReadFrame(s.conn)
+	require.NoError(s.t, err)
+	return p
+}
+
+func (s *fakeServer) reply() *packets.Message {
+	return s.read().(*packets.Message)
+}
+
+func TestBot(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	server := &fakeServer{t: t, listener: listener}
+
+	b, err := NewReminderBot(Config{Name: "remy", Host: "127.0.0.1", Port: listener.Addr().(*net.TCPAddr).Port})
+	require.NoError(t, err)
+	b.reconnectDelay = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- b.Run(ctx) }()
+
+	server.accept(&packets.Message{ID: 1, From: "alice", Payload: "!echo from before the bot"})
+	server.write(&packets.Message{ID: 2, From: "alice", Payload: "!echo hello"})
+	reply := server.reply()
+	assert.Equal(t, "hello", reply.Payload, "replayed messages are skipped")
+	assert.Equal(t, uint64(2), reply.ParentID)
+
+	server.write(&packets.Message{ID: 3, From: "alice", Payload: "!deploy"})
+	assert.Equal(t, "Unknown command !deploy, try !help", server.reply().Payload)
+	server.write(&packets.Message{ID: 4, From: "alice", Payload: "!echo"})
+	assert.Equal(t, "!echo: nothing to echo", server.reply().Payload)
+	server.write(&packets.Message{ID: 5, From: "alice", Payload: "hey @remy, how are you?"})
+	assert.Equal(t, "Hello @alice! Try !help", server.reply().Payload)
+	server.write(&packets.Message{ID: 6, From: "remy", Payload: "!echo my own message"})
+	server.write(&packets.Message{ID: 7, From: "alice", Payload: "!help"})
+	assert.Contains(t, server.reply().Payload, "!remind <duration> <text> — remind you")
+
+	server.write(&packets.Message{ID: 8, From: "alice", Payload: "!remind 1ms stand-up"})
+	assert.Contains(t, server.reply().Payload, "I will remind you at")
+	reply = server.reply()
+	assert.Equal(t, "@alice reminder: stand-up", reply.Payload)
+	assert.Equal(t, uint64(8), reply.ParentID)
+
+	// The messages missed while disconnected are handled once reconnected
+	server.conn.Close()
+	server.accept(&packets.Message{ID: 8, From: "alice", Payload: "!remind 1ms stand-up"}, &packets.Message{ID: 9, From: "alice", Payload: "!echo missed"})
+	assert.Equal(t, "missed", server.reply().Payload)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestState(t *testing.T) {
+	path := t.TempDir() + "/state.json"
+	state, err := openState(path)
+	require.NoError(t, err)
+	require.NoError(t, state.Set("count", 3))
+
+	state, err = openState(path)
+	require.NoError(t, err)
+	var count int
+	found, err := state.Get("count", &count)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 3, count)
+
+	require.NoError(t, state.Delete("count"))
+	found, err = state.Get("count", &count)
+	assert.False(t, found)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,120 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reminder is a message a user asked to be sent later.
+type reminder struct {
+	At       time.Time `json:"at"`
+	User     string    `json:"user"`
+	Text     string    `json:"text"`
+	ThreadID uint64    `json:"thread_id"`
+}
+
+// NewReminderBot creates an example bot that echoes text, greets the users
+// greeting it, and reminds users of things later. Reminders are kept in the
+// state of the bot so that they survive restarts.
+func NewReminderBot(config Config) (*Bot, error) {
+	b, err := New(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// The lock serializes the handlers and the task updating the reminders
+	var mu sync.Mutex
+	load := func() ([]reminder, error) {
+		var reminders []reminder
+		_, err := b.State().Get("reminders", &reminders)
+		return reminders, err
+	}
+
+	b.Command("echo", "echo <text>", "repeat the text", func(c *Context) error {
+		if c.Args == "" {
+			return errors.New("nothing to echo")
+		}
+		return c.Reply(c.Args)
+	})
+
+	b.Command("remind", "remind <duration> <text>", "remind you of the text later, e.g. !remind 10m stand-up", func(c *Context) error {
+		after, text, _ := strings.Cut(c.Args, " ")
+		delay, err := time.ParseDuration(after)
+		if err != nil || delay <= 0 || text == "" {
+			return errors.New("usage: remind <duration> <text>, e.g. 1h30m")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		reminders, err := load()
+		if err != nil {
+			return err
+		}
+
+		r := reminder{At: time.Now().Add(delay), User: c.Message.From, Text: text, ThreadID: c.Message.ID}
+		if err := b.State().Set("reminders", append(reminders, r)); err != nil {
+			return err
+		}
+		return c.Reply(fmt.Sprintf("I will remind you at %s", r.At.Format(time.TimeOnly)))
+	})
+
+	b.Command("reminders", "reminders", "list your pending reminders", func(c *Context) error {
+		mu.Lock()
+		reminders, err := load()
+		mu.Unlock()
+		if err != nil {
+			return err
+		}
+
+		lines := []string{"Your reminders:"}
+		for _, r := range reminders {
+			if r.User == c.Message.From {
+				lines = append(lines, fmt.Sprintf("%s: %s", r.At.Format(time.DateTime), r.Text))
+			}
+		}
+		if len(lines) == 1 {
+			return c.Reply("You have no pending reminders")
+		}
+		return c.Reply(strings.Join(lines, "\n"))
+	})
+
+	greeting := regexp.MustCompile(`(?i)^(hi|hello|hey)\b.*@` + regexp.QuoteMeta(config.Name) + `\b`)
+	b.Pattern(greeting, func(c *Context) error {
+		return c.Reply(fmt.Sprintf("Hello @%s! Try %shelp", c.Message.From, b.config.Prefix))
+	})
+
+	b.Every(time.Second, func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		reminders, err := load()
+		if err != nil {
+			return err
+		}
+
+		due := func(r reminder) bool { return !r.At.After(time.Now()) }
+		if !slices.ContainsFunc(reminders, due) {
+			return nil
+		}
+
+		// Reminders that cannot be sent, e.g. while disconnected, are kept for
+		// the next run
+		var errs []error
+		reminders = slices.DeleteFunc(reminders, func(r reminder) bool {
+			if !due(r) {
+				return false
+			}
+			_, err := b.Client().SendReply(r.ThreadID, fmt.Sprintf("@%s reminder: %s", r.User, r.Text))
+			errs = append(errs, err)
+			return err == nil
+		})
+		errs = append(errs, b.State().Set("reminders", reminders))
+		return errors.Join(errs...)
+	})
+
+	return b, nil
+}
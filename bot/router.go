@@ -0,0 +1,100 @@
+package bot
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/root-man/chat/packets"
+)
+
+// Handler handles a message addressed to the bot. The error it returns, if
+// any, is replied to the sender.
+type Handler func(c *Context) error
+
+// Context is the message a handler is called for.
+type Context struct {
+	Bot     *Bot
+	Message *packets.Message
+	// Args is the text following the name of a command.
+	Args string
+	// Match holds the text matched by a pattern and its submatches.
+	Match []string
+}
+
+// Reply answers in the thread of the message.
+func (c *Context) Reply(text string) error {
+	_, err := c.Bot.client.SendReply(c.Message.ID, text)
+	return err
+}
+
+type command struct {
+	usage       string
+	description string
+	handler     Handler
+}
+
+type pattern struct {
+	re      *regexp.Regexp
+	handler Handler
+}
+
+// Command registers a command, run by messages such as "!deploy staging"
+// whose arguments are then "staging". The usage excludes the prefix.
+func (b *Bot) Command(name, usage, description string, handler Handler) {
+	b.commands[name] = &command{usage: usage, description: description, handler: handler}
+}
+
+// Pattern registers a handler for the messages matching re that are not
+// commands. Only the first pattern matching a message is handled.
+func (b *Bot) Pattern(re *regexp.Regexp, handler Handler) {
+	b.patterns = append(b.patterns, &pattern{re: re, handler: handler})
+}
+
+// handle routes a message to its command or pattern. Handlers run one at a
+// time, in the order messages arrive.
+func (b *Bot) handle(msg *packets.Message) {
+	c := &Context{Bot: b, Message: msg}
+
+	if text, ok := strings.CutPrefix(msg.Payload, b.config.Prefix); ok {
+		name, args, _ := strings.Cut(strings.TrimSpace(text), " ")
+		cmd, ok := b.commands[name]
+		if !ok {
+			b.reply(c, fmt.Sprintf("Unknown command %s%s, try %shelp", b.config.Prefix, name, b.config.Prefix))
+			return
+		}
+
+		logger.Info("User ran command", "user", msg.From, "command", name)
+		c.Args = strings.TrimSpace(args)
+		if err := cmd.handler(c); err != nil {
+			b.reply(c, fmt.Sprintf("%s%s: %s", b.config.Prefix, name, err))
+		}
+		return
+	}
+
+	for _, p := range b.patterns {
+		if c.Match = p.re.FindStringSubmatch(msg.Payload); c.Match != nil {
+			if err := p.handler(c); err != nil {
+				b.reply(c, err.Error())
+			}
+			return
+		}
+	}
+}
+
+func (b *Bot) reply(c *Context, text string) {
+	if err := c.Reply(text); err != nil {
+		logger.Warn("Failed to reply", "user", c.Message.From, "err", err)
+	}
+}
+
+func (b *Bot) help(c *Context) error {
+	lines := []string{"Commands:"}
+	for _, name := range slices.Sorted(maps.Keys(b.commands)) {
+		cmd := b.commands[name]
+		lines = append(lines, fmt.Sprintf("%s%s — %s", b.config.Prefix, cmd.usage, cmd.description))
+	}
+	return c.Reply(strings.Join(lines, "\n"))
+}
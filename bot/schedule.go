@@ -0,0 +1,34 @@
+package bot
+
+import (
+	"context"
+	"time"
+)
+
+type task struct {
+	interval time.Duration
+	run      func() error
+}
+
+// Every registers a task run at a regular interval while the bot runs,
+// whether or not it is connected. Failures are logged, and the task is run
+// again at the next interval.
+func (b *Bot) Every(interval time.Duration, run func() error) {
+	b.tasks = append(b.tasks, &task{interval: interval, run: run})
+}
+
+func (t *task) start(ctx context.Context) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := t.run(); err != nil {
+				logger.Warn("Scheduled task failed", "err", err)
+			}
+		}
+	}
+}
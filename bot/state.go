@@ -0,0 +1,101 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// State is the memory of a bot, a set of JSON values by key persisted across
+// restarts when the bot has a state file.
+type State struct {
+	path   string
+	mu     sync.Mutex
+	values map[string]json.RawMessage
+}
+
+func openState(path string) (*State, error) {
+	s := &State{path: path, values: make(map[string]json.RawMessage)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &s.values); err != nil {
+		return nil, fmt.Errorf("invalid bot state %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Get decodes the value of key into v, telling whether there is one.
+func (s *State) Get(key string, v any) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.values[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// Set stores the value of key.
+func (s *State) Set(key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = data
+	return s.save()
+}
+
+// Delete forgets the value of key.
+func (s *State) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	return s.save()
+}
+
+// save writes the state to its file, replacing it atomically. The caller
+// must hold s.mu.
+func (s *State) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(s.values)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save bot state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save bot state: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save bot state: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -1,96 +1,273 @@
 package client
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net"
+	"slices"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/root-man/chat/logging"
 	"github.com/root-man/chat/packets"
 )
 
+// systemUser is the sender name the server uses for itself.
+const systemUser = "CHAT"
+
+// logger writes to the log file of the client, never to the terminal the
+// interface is drawn on.
+var logger = logging.For("client")
+
 type Client struct {
 	name        string
 	conn        net.Conn
 	usersOnline []string
+	// compress is set when the server agreed to compressed frames.
+	compress bool
+	mu       sync.Mutex
 }
 
 func New(name string) *Client {
 	return &Client{name: name}
 }
 
-func (c *Client) Connect(serverHost string, serverPort int) (<-chan packets.Message, error) {
-	log.Printf("Client %s connecting to chat server %s:%d", c.name, serverHost, serverPort)
+func (c *Client) Connect(serverHost string, serverPort int) (<-chan packets.Packet, error) {
+	logger.Info("Connecting to chat server", "user", c.Name(), "host", serverHost, "port", serverPort)
 	tcpServer, err := net.ResolveTCPAddr("tcp", serverHost+":"+strconv.Itoa(serverPort))
 	if err != nil {
-		log.Printf("ResolveTCPAddr failed: %s", err)
+		logger.Error("Failed to resolve the server address", "err", err)
 		return nil, err
 	}
 
 	conn, err := net.DialTCP("tcp", nil, tcpServer)
 	if err != nil {
-		log.Printf("Dial failed: %s", err)
+		logger.Error("Failed to connect", "err", err)
 		return nil, err
 	}
 
-	c.conn = conn
-
-	log.Printf("Connection successful, initiating handshake...")
+	logger.Info("Connection successful, initiating handshake")
 
-	if err := c.handshake(); err != nil {
-		log.Printf("error on handshake: %s", err)
+	if err := c.handshake(conn); err != nil {
+		logger.Error("Handshake failed", "err", err)
+		conn.Close()
 		return nil, err
 	}
 
-	log.Printf("Handshake completed")
+	logger.Info("Handshake completed")
 
-	msgChan := make(chan packets.Message)
+	msgChan := make(chan packets.Packet)
 
-	go c.listen(msgChan)
+	go c.listen(conn, msgChan)
 
 	return msgChan, nil
 }
 
-func (c *Client) handshake() error {
-	h := packets.Handshake{Username: c.name}
-	_, err := c.conn.Write(h.Encode())
+// handshake introduces the client on a new connection, which it then uses.
+func (c *Client) handshake(conn net.Conn) error {
+	h := packets.Handshake{Username: c.Name(), Capabilities: packets.CapCompression, Version: packets.ProtocolVersion}
+	_, err := conn.Write(packets.Frame(&h))
 	if err != nil {
 		return fmt.Errorf("handshake failed: %s", err)
 	}
 
-	resp := &packets.HandshakeResponse{}
-
-	if err = resp.Receive(c.conn); err != nil {
+	p, err := packets.ReadFrame(conn)
+	if err != nil {
 		return fmt.Errorf("handshake failed: %s", err)
 	}
 
+	resp, ok := p.(*packets.HandshakeResponse)
+	if !ok {
+		return fmt.Errorf("handshake failed: unexpected response %s", p)
+	}
+
+	if resp.Version != packets.ProtocolVersion {
+		return fmt.Errorf("handshake failed: the server speaks protocol version %d and this client %d, please upgrade", resp.Version, packets.ProtocolVersion)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn = conn
 	c.usersOnline = resp.OnlineUsers
+	c.compress = resp.Capabilities&packets.CapCompression != 0
 
 	return nil
 }
 
-func (c *Client) listen(msgChan chan<- packets.Message) {
-	defer c.conn.Close()
-
-	msg := &packets.Message{}
+func (c *Client) listen(conn net.Conn, msgChan chan<- packets.Packet) {
+	defer conn.Close()
+	defer close(msgChan)
 
+	r := packets.NewReader(conn)
 	for {
-		if err := msg.Receive(c.conn); err != nil {
-			fmt.Printf("Failed to deserialize message: %s", err)
-			continue
+		p, err := r.ReadPacket()
+		if err != nil {
+			var unknown *packets.UnknownTypeError
+			if errors.As(err, &unknown) {
+				continue
+			}
+
+			if err != io.EOF {
+				logger.Error("Failed to deserialize packet", "err", err)
+			}
+			return
+		}
+
+		logger.Debug("Received packet", "packet", p)
+		c.track(p)
+		msgChan <- p
+	}
+}
+
+// track keeps the client's view of its own name and of the online users in
+// sync with the packets received from the server.
+func (c *Client) track(p packets.Packet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch p := p.(type) {
+	case *packets.Presence:
+		if p.Username == c.name {
+			return
+		}
+		c.usersOnline = slices.DeleteFunc(c.usersOnline, func(u string) bool { return u == p.Username })
+		if p.Status {
+			c.usersOnline = append(c.usersOnline, p.Username)
 		}
+	case *packets.Rename:
+		if p.From == c.name {
+			c.name = p.To
+			return
+		}
+		if i := slices.Index(c.usersOnline, p.From); i >= 0 {
+			c.usersOnline[i] = p.To
+		}
+	}
+}
+
+// write sends p to the server, compressed if it is large enough and the
+// server supports it.
+func (c *Client) write(p packets.Packet) error {
+	c.mu.Lock()
+	conn, compress := c.conn, c.compress
+	c.mu.Unlock()
+	if conn == nil {
+		return errors.New("not connected")
+	}
+
+	frame := packets.Frame(p)
+	if compress {
+		frame = packets.Compress(frame)
+	}
+
+	if _, err := conn.Write(frame); err != nil {
+		return err
+	}
+
+	logger.Debug("Sent packet", "packet", p)
+	return nil
+}
 
-		msgChan <- *msg
+// Close disconnects from the server, which ends the stream of packets.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
 	}
+	return c.conn.Close()
+}
+
+// Name returns the username the client is currently known by.
+func (c *Client) Name() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.name
+}
+
+// UsersOnline returns the other users currently connected, sorted by name.
+func (c *Client) UsersOnline() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Sorted(slices.Values(c.usersOnline))
 }
 
 func (c *Client) Send(message string) (*packets.Message, error) {
-	msg := &packets.Message{From: c.name, Payload: message, Timestamp: time.Now()}
-	_, err := c.conn.Write(msg.Encode())
-	if err != nil {
+	return c.SendReply(0, message)
+}
+
+// SendReply sends a message in the thread of the message with the given ID.
+func (c *Client) SendReply(parentID uint64, message string) (*packets.Message, error) {
+	msg := &packets.Message{ParentID: parentID, From: c.Name(), Payload: message, Timestamp: time.Now()}
+	if err := c.write(msg); err != nil {
 		return nil, err
 	}
 
 	return msg, nil
 }
+
+// SendTyping tells the other users whether this user is typing.
+func (c *Client) SendTyping(typing bool) error {
+	return c.write(&packets.Typing{Username: c.Name(), Typing: typing})
+}
+
+// SendReadReceipt tells the server that the user has seen every message up to
+// messageID.
+func (c *Client) SendReadReceipt(messageID uint64) error {
+	return c.write(&packets.ReadReceipt{Username: c.Name(), MessageID: messageID})
+}
+
+// Edit replaces the text of one of the user's messages.
+func (c *Client) Edit(messageID uint64, payload string) error {
+	return c.write(&packets.MessageEdit{ID: messageID, Payload: payload})
+}
+
+// Delete removes one of the user's messages.
+func (c *Client) Delete(messageID uint64) error {
+	return c.write(&packets.MessageDelete{ID: messageID})
+}
+
+// React adds or removes an emoji reaction on a message.
+func (c *Client) React(messageID uint64, emoji string, add bool) error {
+	return c.write(&packets.Reaction{MessageID: messageID, Username: c.Name(), Emoji: emoji, Add: add})
+}
+
+// Search asks the server for the stored messages matching req. The results
+// arrive as a SearchResults packet.
+func (c *Client) Search(req *packets.SearchRequest) error {
+	return c.write(req)
+}
+
+// OfferFile announces a file to a user, or to the room when offer.To is
+// empty. The server echoes the offer back with its ID.
+func (c *Client) OfferFile(offer *packets.FileOffer) error {
+	return c.write(offer)
+}
+
+// AcceptFile asks to receive an offered file.
+func (c *Client) AcceptFile(id uint64) error {
+	return c.write(&packets.FileAccept{ID: id, Username: c.Name()})
+}
+
+// SendFileChunk uploads the part of a file starting at offset.
+func (c *Client) SendFileChunk(id uint64, offset uint64, data []byte) error {
+	return c.write(&packets.FileChunk{ID: id, Offset: offset, Data: data})
+}
+
+// CompleteFile marks the end of an upload.
+func (c *Client) CompleteFile(id uint64) error {
+	return c.write(&packets.FileComplete{ID: id})
+}
+
+// CancelFile aborts a transfer, for everyone if the user is its sender.
+func (c *Client) CancelFile(id uint64, reason string) error {
+	return c.write(&packets.FileCancel{ID: id, Username: c.Name(), Reason: reason})
+}
+
+// SendCommand forwards a slash command to the server.
+func (c *Client) SendCommand(name string, args string) error {
+	cmd := &packets.Command{Name: name, Args: args}
+	return c.write(cmd)
+}
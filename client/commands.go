@@ -0,0 +1,133 @@
+package client
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+type command struct {
+	usage       string
+	description string
+	// run executes a client-local command. Commands without it are forwarded
+	// to the server.
+	run func(v *chatView, args string)
+}
+
+// commands lists every slash command known to the client, used for dispatch,
+// /help and tab completion.
+var commands map[string]command
+
+func init() {
+	// Populated in init since /help refers back to the registry.
+	commands = map[string]command{
+		"quit": {
+			usage:       "/quit",
+			description: "leave the chat",
+			run:         func(v *chatView, _ string) { v.app.Stop() },
+		},
+		"clear": {
+			usage:       "/clear",
+			description: "clear the chat window",
+			run: func(v *chatView, _ string) {
+				v.timeline.clear()
+				v.renderTimeline()
+			},
+		},
+		"help": {
+			usage:       "/help",
+			description: "show this help",
+			run:         (*chatView).showHelp,
+		},
+		"edit": {
+			usage:       "/edit <text>",
+			description: "edit the selected message (Alt+Up/Alt+Down), or your last one",
+			run:         (*chatView).editMessage,
+		},
+		"delete": {
+			usage:       "/delete",
+			description: "delete the selected message, or your last one",
+			run:         (*chatView).deleteMessage,
+		},
+		"react": {
+			usage:       "/react [emoji]",
+			description: "toggle a reaction on the selected or newest message (Ctrl+R)",
+			run:         (*chatView).react,
+		},
+		"thread": {
+			usage:       "/thread",
+			description: "open the thread of the selected message, or close the open one (Ctrl+T)",
+			run:         (*chatView).toggleThread,
+		},
+		"search": {
+			usage:       "/search [query]",
+			description: "search the history, filtering with from:user since:date until:date (Ctrl+F)",
+			run:         (*chatView).search,
+		},
+		"send": {
+			usage:       "/send [@user] <path>",
+			description: "send a file to a user, or to the whole room",
+			run:         (*chatView).sendFile,
+		},
+		"accept": {
+			usage:       "/accept <id>",
+			description: "download a file offered to you or stored on the server",
+			run:         (*chatView).acceptFile,
+		},
+		"cancel": {
+			usage:       "/cancel <id>",
+			description: "stop sending or downloading a file, or decline an offer",
+			run:         (*chatView).cancelFile,
+		},
+		"files": {
+			usage:       "/files",
+			description: "list the files stored on the server",
+		},
+		"nick": {
+			usage:       "/nick <name>",
+			description: "change your username",
+		},
+		"who": {
+			usage:       "/who",
+			description: "list the users currently online",
+		},
+		"me": {
+			usage:       "/me <action>",
+			description: "send an action, e.g. /me waves",
+		},
+		"mentions": {
+			usage:       "/mentions",
+			description: "list the recent messages mentioning you",
+		},
+		"topic": {
+			usage:       "/topic [text]",
+			description: "show or set the room topic",
+		},
+	}
+}
+
+// parseCommand splits a "/name args" line. Lines starting with "//" are not
+// commands, so that a message can start with a slash.
+func parseCommand(line string) (name string, args string, ok bool) {
+	if !strings.HasPrefix(line, "/") || strings.HasPrefix(line, "//") {
+		return "", "", false
+	}
+
+	name, args, _ = strings.Cut(line[1:], " ")
+	if name == "" {
+		return "", "", false
+	}
+
+	return strings.ToLower(name), strings.TrimSpace(args), true
+}
+
+// commandCompletions returns the command lines matching the partial "/name".
+func commandCompletions(prefix string) []string {
+	var matches []string
+	for _, name := range slices.Sorted(maps.Keys(commands)) {
+		if strings.HasPrefix("/"+name, prefix) {
+			matches = append(matches, "/"+name)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		line string
+		name string
+		args string
+		ok   bool
+	}{
+		{line: "/who", name: "who", ok: true},
+		{line: "/ME  waves happily ", name: "me", args: "waves happily", ok: true},
+		{line: "hello /who", ok: false},
+		{line: "//not a command", ok: false},
+		{line: "/ nothing", ok: false},
+	}
+
+	for _, tt := range tests {
+		name, args, ok := parseCommand(tt.line)
+		assert.Equal(t, tt.ok, ok, tt.line)
+		assert.Equal(t, tt.name, name, tt.line)
+		assert.Equal(t, tt.args, args, tt.line)
+	}
+}
+
+func TestCompleter(t *testing.T) {
+	c := &completer{candidates: commandCompletions}
+
+	assert.Equal(t, "/who ", c.complete("/wh"))
+	assert.Equal(t, "/who ", c.complete("/who "))
+
+	first := c.complete("/q")
+	assert.Equal(t, "/quit ", first)
+	assert.Equal(t, "hello", c.complete("hello"))
+}
+
+func TestParseSearchArgs(t *testing.T) {
+	fields := parseSearchArgs("deploy from:@alice link since:2026-10-01 until:2026-10-02")
+	assert.Equal(t, searchFields{query: "deploy link", from: "alice", since: "2026-10-01", until: "2026-10-02"}, fields)
+
+	req, err := fields.request()
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 10, 1, 0, 0, 0, 0, time.Local), req.Since)
+	assert.Equal(t, time.Date(2026, 10, 3, 0, 0, 0, 0, time.Local), req.Until, "until includes the whole day")
+
+	_, err = searchFields{since: "last week"}.request()
+	assert.Error(t, err)
+}
@@ -0,0 +1,37 @@
+package client
+
+import "strings"
+
+// completer implements tab completion of the last word of the input. Pressing
+// Tab repeatedly cycles through the matches.
+type completer struct {
+	candidates func(word string) []string
+
+	head    string
+	matches []string
+	index   int
+	last    string
+}
+
+func (c *completer) complete(text string) string {
+	if text == c.last && len(c.matches) > 0 {
+		c.index = (c.index + 1) % len(c.matches)
+	} else {
+		i := strings.LastIndex(text, " ") + 1
+		c.head = text[:i]
+		c.matches = c.candidates(text[i:])
+		c.index = 0
+	}
+
+	if len(c.matches) == 0 {
+		c.last = ""
+		return text
+	}
+
+	c.last = c.head + c.matches[c.index]
+	if len(c.matches) == 1 {
+		c.last += " "
+	}
+
+	return c.last
+}
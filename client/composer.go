@@ -0,0 +1,137 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+const (
+	// maxComposerRows is the height the composer grows to before scrolling.
+	maxComposerRows = 8
+	// largePasteLines and largePasteSize tell when a paste is large enough
+	// for sending it to need a confirmation.
+	largePasteLines = 20
+	largePasteSize  = 4096
+)
+
+// composer is the multi-line editor messages are written in. Enter sends the
+// message while Alt+Enter, Shift+Enter or Ctrl+J start a new line.
+type composer struct {
+	*tview.TextArea
+	// pasted is set when a large block was pasted since the last message
+	// was sent.
+	pasted bool
+}
+
+func newComposer() *composer {
+	c := &composer{TextArea: tview.NewTextArea()}
+	c.SetPlaceholder("Enter to send, Alt+Enter for a new line, Ctrl+O to open $EDITOR").
+		SetPlaceholderStyle(tcell.StyleDefault.Foreground(tcell.ColorGray))
+	return c
+}
+
+// PasteHandler notes large pastes before inserting them.
+func (c *composer) PasteHandler() func(pastedText string, setFocus func(p tview.Primitive)) {
+	handler := c.TextArea.PasteHandler()
+	return func(pastedText string, setFocus func(p tview.Primitive)) {
+		if isLarge(pastedText) {
+			c.pasted = true
+		}
+		handler(pastedText, setFocus)
+	}
+}
+
+// clear empties the composer once its text was sent.
+func (c *composer) clear() {
+	c.SetText("", false)
+	c.pasted = false
+}
+
+// rows returns the height the composer needs to show its text.
+func (c *composer) rows() int {
+	rows := strings.Count(c.GetText(), "\n") + 1
+	if rows <= maxComposerRows {
+		// The text fits, do not leave it scrolled from when it did not
+		c.SetOffset(0, 0)
+	}
+	return min(max(rows, 2), maxComposerRows)
+}
+
+// isLarge reports whether text is too large to send without a confirmation.
+func isLarge(text string) bool {
+	return len(text) > largePasteSize || strings.Count(text, "\n") >= largePasteLines
+}
+
+// send sends the composed message, asking first if it holds a large paste.
+func (v *chatView) send() {
+	text := strings.TrimRight(v.composer.GetText(), "\n")
+	if !v.composer.pasted || !isLarge(text) {
+		v.submit(text)
+		v.composer.clear()
+		return
+	}
+
+	lines := strings.Count(text, "\n") + 1
+	modal := tview.NewModal().
+		SetText(fmt.Sprintf("Send the %d lines (%s) you pasted?", lines, formatSize(uint64(len(text))))).
+		AddButtons([]string{"Send", "Keep editing"}).
+		SetDoneFunc(func(_ int, label string) {
+			v.closeModal("paste")
+			if label == "Send" {
+				v.submit(text)
+				v.composer.clear()
+			}
+		})
+	v.pages.AddPage("paste", modal, false, true)
+	v.app.SetFocus(modal)
+}
+
+// openEditor suspends the interface to edit the message in $VISUAL or
+// $EDITOR.
+func (v *chatView) openEditor() {
+	editor := strings.Fields(os.Getenv("VISUAL"))
+	if len(editor) == 0 {
+		editor = strings.Fields(os.Getenv("EDITOR"))
+	}
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+
+	f, err := os.CreateTemp("", "chat-*.md")
+	if err != nil {
+		v.systemMessage("Failed to open the editor: " + err.Error())
+		return
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(v.composer.GetText())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		v.app.Suspend(func() {
+			cmd := exec.Command(editor[0], append(editor[1:], f.Name())...)
+			cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+			err = cmd.Run()
+		})
+	}
+	if err != nil {
+		v.systemMessage(fmt.Sprintf("Failed to run %s: %s", editor[0], err))
+		return
+	}
+
+	text, err := os.ReadFile(f.Name())
+	if err != nil {
+		v.systemMessage("Failed to read the message back: " + err.Error())
+		return
+	}
+
+	// Editors end files with a newline that is not part of the message
+	v.composer.SetText(strings.TrimRight(string(text), "\n"), true)
+}
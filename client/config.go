@@ -0,0 +1,10 @@
+package client
+
+// Config holds the settings the client is started with.
+type Config struct {
+	// DownloadDir is where the files received from other users are saved.
+	DownloadDir string
+	// TimeFormat is the Go time layout message times are shown with, in the
+	// local time zone.
+	TimeFormat string
+}
@@ -1,8 +1,11 @@
 package client
 
 import (
-	"log"
+	"fmt"
+	"maps"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/gdamore/tcell/v2"
@@ -10,7 +13,7 @@ import (
 	"github.com/root-man/chat/packets"
 )
 
-func StartInterface() {
+func StartInterface(config Config) {
 	var c *Client
 	app := tview.NewApplication()
 	connectForm := tview.NewForm()
@@ -23,11 +26,14 @@ func StartInterface() {
 
 			msgChan, err := c.Connect("localhost", 4444)
 			if err != nil {
-				log.Printf("Failed to init the client: %s", err)
+				logger.Error("Failed to connect", "err", err)
+				// The screen is restored before telling the user why
+				app.Stop()
+				fmt.Fprintf(os.Stderr, "Failed to connect to the chat server: %s\n", err)
 				os.Exit(2)
 			}
 
-			renderChatView(app, c, msgChan)
+			renderChatView(app, c, msgChan, config)
 		}).
 		AddButton("Quit", func() {
 			app.Stop()
@@ -38,71 +44,457 @@ func StartInterface() {
 	}
 }
 
-func renderChatView(app *tview.Application, c *Client, msgChan <-chan packets.Message) {
-	newPrimitive := func(text string) tview.Primitive {
-		return tview.NewTextView().
-			SetTextAlign(tview.AlignCenter).
-			SetText(text)
-	}
+// chatView holds the widgets of the main chat screen.
+type chatView struct {
+	app        *tview.Application
+	pages      *tview.Pages
+	client     *Client
+	headerText *tview.TextView
+	chatBox    *tview.TextView
+	chatArea   *tview.Flex
+	threadBox  *tview.TextView
+	usersList  *tview.TextView
+	typingText *tview.TextView
+	// transferText shows the progress of the file transfers.
+	transferText *tview.TextView
+	// layout is the grid of the chat screen, its last row grows with the
+	// composer.
+	layout      *tview.Grid
+	composer    *composer
+	completer   *completer
+	topic       *packets.Topic
+	typing      *typingNotifier
+	typingUsers typingUsers
+	timeline    *timeline
+	lastRead    uint64
+	// selected is the ID of the message picked with Alt+Up/Alt+Down, if any.
+	selected uint64
+	// thread is the ID of the message whose thread is open, if any.
+	thread uint64
+	// bell rings the terminal bell on the next draw.
+	bell bool
+	// searchDialog is the open search window, if any.
+	searchDialog *searchDialog
+	transfers    *transfers
+}
+
+func renderChatView(app *tview.Application, c *Client, msgChan <-chan packets.Packet, config Config) {
+	v := &chatView{app: app, client: c, typing: &typingNotifier{send: c.SendTyping}, typingUsers: typingUsers{}, timeline: newTimeline(config.TimeFormat), transfers: newTransfers(config.DownloadDir)}
 
 	button := tview.NewButton("Quit").SetSelectedFunc(func() {
 		app.Stop()
 	})
 
-	headerText := newPrimitive("Welcome to mega chat! you are connected as " + c.name)
+	v.headerText = tview.NewTextView().SetTextAlign(tview.AlignCenter)
+	v.renderHeader()
 
 	header := tview.NewFlex().
-		AddItem(headerText, 0, 1, false).
+		AddItem(v.headerText, 0, 1, false).
 		AddItem(button, 20, 1, false)
 
-	usersList := tview.NewTextView().SetTextAlign(tview.AlignLeft).SetDynamicColors(true)
-	chatBox := tview.NewTextView().SetTextAlign(tview.AlignLeft).SetDynamicColors(true)
+	v.usersList = tview.NewTextView().SetTextAlign(tview.AlignLeft).SetDynamicColors(true)
+	v.chatBox = tview.NewTextView().SetTextAlign(tview.AlignLeft).SetDynamicColors(true).SetRegions(true)
+	v.threadBox = tview.NewTextView().SetTextAlign(tview.AlignLeft).SetDynamicColors(true).SetRegions(true)
+	v.threadBox.SetBorder(true).SetTitle("Thread (Ctrl+T to close)")
+	v.chatArea = tview.NewFlex().AddItem(v.chatBox, 0, 1, false)
+	v.typingText = tview.NewTextView().SetTextAlign(tview.AlignLeft).SetTextColor(tcell.ColorGray)
+	v.transferText = tview.NewTextView().SetTextAlign(tview.AlignRight).SetTextColor(tcell.ColorGreen)
 
-	usersList.SetLabel("User list")
-	usersList.Write([]byte("\n======="))
+	v.renderUsers()
 
-	for _, u := range c.usersOnline {
-		usersList.Write([]byte(u + "\n"))
-	}
-
-	inputField := tview.NewInputField()
-	inputField.SetLabel("Message: ").SetFieldWidth(0).SetDoneFunc(func(key tcell.Key) {
-		if key == tcell.KeyEnter {
-			msgText := inputField.GetText()
-			msg, err := c.Send(msgText)
-			if err != nil {
-				// do something
-			}
-			chatBox.Write(chatViewOwnMsgFormat(msg))
-			inputField.SetText("")
+	v.completer = &completer{candidates: v.completions}
+	v.composer = newComposer()
+	v.composer.SetLabel("Message: ")
+	v.composer.SetChangedFunc(func() {
+		v.typing.changed(v.composer.GetText())
+		v.layout.SetRows(1, 0, 1, v.composer.rows())
+	})
+	v.composer.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEnter && event.Modifiers()&(tcell.ModAlt|tcell.ModShift) == 0:
+			v.send()
+			return nil
+		case event.Key() == tcell.KeyEnter || event.Key() == tcell.KeyCtrlJ:
+			return tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone)
+		case event.Key() == tcell.KeyCtrlO:
+			v.openEditor()
+			return nil
+		case event.Key() == tcell.KeyTab:
+			v.composer.SetText(v.completer.complete(v.composer.GetText()), true)
+			return nil
+		case event.Key() == tcell.KeyUp && event.Modifiers()&tcell.ModAlt != 0:
+			v.selectMessage(v.timeline.neighbour(v.selected, -1))
+			return nil
+		case event.Key() == tcell.KeyDown && event.Modifiers()&tcell.ModAlt != 0:
+			v.selectMessage(v.timeline.neighbour(v.selected, 1))
+			return nil
+		case event.Key() == tcell.KeyCtrlR:
+			v.showReactionPicker()
+			return nil
+		case event.Key() == tcell.KeyCtrlT:
+			v.toggleThread("")
+			return nil
+		case event.Key() == tcell.KeyCtrlF:
+			v.search("")
+			return nil
+		case event.Key() == tcell.KeyEscape && v.selected != 0:
+			v.selectMessage(0)
+			return nil
 		}
+		return event
 	})
 
-	grid := tview.NewGrid().
-		SetRows(1, 0, 2).
+	v.layout = tview.NewGrid().
+		SetRows(1, 0, 1, 2).
 		SetColumns(30, 0, 30).
 		SetBorders(true).
 		AddItem(header, 0, 0, 1, 3, 0, 0, false).
-		AddItem(usersList, 1, 0, 1, 1, 0, 0, false).
-		AddItem(chatBox, 1, 1, 1, 2, 0, 0, false).
-		AddItem(inputField, 2, 0, 1, 3, 0, 0, false)
+		AddItem(v.usersList, 1, 0, 2, 1, 0, 0, false).
+		AddItem(v.chatArea, 1, 1, 1, 2, 0, 0, false).
+		AddItem(v.typingText, 2, 1, 1, 1, 0, 0, false).
+		AddItem(v.transferText, 2, 2, 1, 1, 0, 0, false).
+		AddItem(v.composer, 3, 0, 1, 3, 0, 0, false)
 
-	app.SetRoot(grid, true).SetFocus(inputField).Sync()
+	v.pages = tview.NewPages().AddPage("chat", v.layout, true, true)
+	app.SetRoot(v.pages, true).SetFocus(v.composer).EnablePaste(true).Sync()
+	app.SetAfterDrawFunc(func(screen tcell.Screen) {
+		if v.bell {
+			v.bell = false
+			screen.Beep()
+		}
+	})
 
-	// Goroutine to receive messages
+	// Goroutine to receive packets
 	go func() {
-		for msg := range msgChan {
+		for p := range msgChan {
 			app.QueueUpdateDraw(func() {
-				chatBox.Write(chatViewMsgFormat(&msg))
+				v.handlePacket(p)
 			})
 		}
 	}()
+
+	// Goroutine to expire typing indicators that were never stopped
+	go func() {
+		for range time.Tick(time.Second) {
+			app.QueueUpdateDraw(v.renderTyping)
+		}
+	}()
+}
+
+func (v *chatView) handlePacket(p packets.Packet) {
+	switch p := p.(type) {
+	case *packets.Message:
+		v.timeline.addMessage(p)
+		v.renderTimeline()
+		if v.timeline.replayed && mentions(p, v.client.Name()) {
+			v.bell = true
+		}
+		v.markRead()
+		v.typingUsers.set(p.From, false)
+		v.renderTyping()
+	case *packets.ReadReceipt:
+		if p.Username != v.client.Name() {
+			v.timeline.setReadBy(p.Username, p.MessageID)
+		} else if !v.timeline.replayed {
+			v.lastRead = p.MessageID
+			v.timeline.replayDone(p.MessageID, v.client.Name())
+			v.markRead()
+		}
+		v.renderTimeline()
+	case *packets.MessageEdit:
+		v.timeline.edit(p.ID, p.Payload)
+		v.renderTimeline()
+	case *packets.MessageDelete:
+		v.timeline.delete(p.ID)
+		if v.selected == p.ID {
+			v.selectMessage(0)
+		}
+		v.renderTimeline()
+	case *packets.MentionList:
+		v.showMentions(p)
+	case *packets.SearchResults:
+		v.showSearchResults(p)
+	case *packets.FileOffer, *packets.FileAccept, *packets.FileChunk, *packets.FileComplete, *packets.FileCancel:
+		v.handleFilePacket(p)
+	case *packets.Reaction:
+		v.timeline.react(p.MessageID, p.Username, p.Emoji, p.Add)
+		v.renderTimeline()
+	case *packets.Typing:
+		v.typingUsers.set(p.Username, p.Typing)
+		v.renderTyping()
+	case *packets.Presence:
+		if !p.Status {
+			v.typingUsers.set(p.Username, false)
+			v.renderTyping()
+		}
+		v.renderUsers()
+	case *packets.Motd:
+		for _, line := range strings.Split(p.Text, "\n") {
+			v.systemMessage(line)
+		}
+	case *packets.Topic:
+		if v.topic != nil {
+			v.systemMessage(fmt.Sprintf("%s changed the topic to: %s", p.SetBy, p.Text))
+		}
+		v.topic = p
+		v.renderHeader()
+	case *packets.Rename:
+		v.timeline.rename(p.From, p.To)
+		v.systemMessage(fmt.Sprintf("%s is now known as %s", p.From, p.To))
+		v.typingUsers.set(p.From, false)
+		v.renderTyping()
+		v.renderHeader()
+		v.renderUsers()
+	}
+}
+
+func (v *chatView) renderHeader() {
+	if v.topic == nil || v.topic.Text == "" {
+		v.headerText.SetText("Welcome to mega chat! you are connected as " + v.client.Name())
+		return
+	}
+
+	v.headerText.SetText(fmt.Sprintf("#%s: %s | you are connected as %s", v.topic.Room, v.topic.Text, v.client.Name()))
+}
+
+// markRead tells the server that the user has seen the newest message once
+// the history replay is over.
+func (v *chatView) markRead() {
+	latest := v.timeline.latestID()
+	if !v.timeline.replayed || latest <= v.lastRead {
+		return
+	}
+
+	if err := v.client.SendReadReceipt(latest); err == nil {
+		v.lastRead = latest
+	}
+}
+
+func (v *chatView) renderTimeline() {
+	v.chatBox.SetText(string(v.timeline.render(v.client.Name())))
+	if v.selected == 0 {
+		v.chatBox.ScrollToEnd()
+	}
+
+	if v.thread != 0 {
+		v.threadBox.SetText(string(v.timeline.renderThread(v.thread, v.client.Name()))).ScrollToEnd()
+	}
+}
+
+// toggleThread opens the thread of the selected message next to the chat
+// window, or closes the thread pane if it is open.
+func (v *chatView) toggleThread(_ string) {
+	if v.thread != 0 {
+		v.thread = 0
+		v.chatArea.RemoveItem(v.threadBox)
+		v.composer.SetLabel("Message: ")
+		return
+	}
+
+	if v.selected == 0 {
+		v.systemMessage("Select a message with Alt+Up/Alt+Down to open its thread")
+		return
+	}
+
+	v.thread = v.selected
+	v.selectMessage(0)
+	v.chatArea.AddItem(v.threadBox, 0, 1, false)
+	v.composer.SetLabel("Reply: ")
+	v.renderTimeline()
+}
+
+// selectMessage highlights the message with the given ID, or clears the
+// selection for an ID of 0.
+func (v *chatView) selectMessage(id uint64) {
+	v.selected = id
+	if id == 0 {
+		v.chatBox.Highlight()
+		v.chatBox.ScrollToEnd()
+		return
+	}
+
+	v.chatBox.Highlight(messageRegion(id)).ScrollToHighlight()
+}
+
+// target returns the message an /edit or /delete applies to: the selected one,
+// or else the user's newest message.
+func (v *chatView) target() uint64 {
+	if v.selected != 0 {
+		return v.selected
+	}
+	return v.timeline.lastFrom(v.client.Name())
+}
+
+func (v *chatView) editMessage(args string) {
+	id := v.target()
+	if id == 0 {
+		v.systemMessage("There is no message to edit")
+		return
+	}
+
+	if args == "" {
+		v.systemMessage("Usage: /edit <text>, use /delete to remove a message")
+		return
+	}
+
+	if err := v.client.Edit(id, args); err != nil {
+		v.systemMessage("Failed to edit message: " + err.Error())
+		return
+	}
+	v.selectMessage(0)
+}
+
+func (v *chatView) deleteMessage(_ string) {
+	id := v.target()
+	if id == 0 {
+		v.systemMessage("There is no message to delete")
+		return
+	}
+
+	if err := v.client.Delete(id); err != nil {
+		v.systemMessage("Failed to delete message: " + err.Error())
+		return
+	}
+	v.selectMessage(0)
+}
+
+// showModal shows p centered over the chat view.
+func (v *chatView) showModal(name string, p tview.Primitive, width int, height int) {
+	modal := tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(p, height, 1, true).
+			AddItem(nil, 0, 1, false), width, 1, true).
+		AddItem(nil, 0, 1, false)
+
+	v.pages.AddPage(name, modal, true, true)
+	v.app.SetFocus(p)
+}
+
+func (v *chatView) closeModal(name string) {
+	v.pages.RemovePage(name)
+	v.app.SetFocus(v.composer)
+}
+
+func (v *chatView) renderTyping() {
+	v.typingText.SetText(v.typingUsers.String())
 }
 
-func chatViewMsgFormat(m *packets.Message) []byte {
-	return []byte("[blue]" + m.Timestamp.Format(time.DateTime) + " [yellow]" + m.From + "[white]: " + m.Payload + "\n")
+func (v *chatView) renderUsers() {
+	v.usersList.Clear()
+	v.usersList.SetLabel("User list")
+	v.usersList.Write([]byte("\n=======\n"))
+
+	for _, u := range v.client.UsersOnline() {
+		v.usersList.Write([]byte(u + "\n"))
+	}
 }
 
-func chatViewOwnMsgFormat(m *packets.Message) []byte {
-	return []byte("[blue]" + m.Timestamp.Format(time.DateTime) + " [yellow]Me" + "[white]: " + m.Payload + "\n")
+// submit sends the text typed by the user, running it as a command if it is
+// one.
+func (v *chatView) submit(text string) {
+	v.typing.stop()
+	if text == "" {
+		return
+	}
+
+	name, args, ok := parseCommand(text)
+	if !ok {
+		if strings.HasPrefix(text, "//") {
+			text = text[1:]
+		}
+		if v.thread != 0 {
+			if _, err := v.client.SendReply(v.thread, text); err != nil {
+				v.systemMessage("Failed to send reply: " + err.Error())
+			}
+			return
+		}
+
+		if _, err := v.client.Send(text); err != nil {
+			v.systemMessage("Failed to send message: " + err.Error())
+			return
+		}
+		// Replying means the user caught up, the divider is not needed anymore
+		v.timeline.unreadFrom = 0
+		return
+	}
+
+	cmd, known := commands[name]
+	if known && cmd.run != nil {
+		cmd.run(v, args)
+		return
+	}
+
+	if err := v.client.SendCommand(name, args); err != nil {
+		v.systemMessage("Failed to send command: " + err.Error())
+	}
+}
+
+func (v *chatView) showHelp(_ string) {
+	for _, name := range slices.Sorted(maps.Keys(commands)) {
+		cmd := commands[name]
+		v.systemMessage(fmt.Sprintf("%-16s %s", cmd.usage, cmd.description))
+	}
+}
+
+func (v *chatView) systemMessage(text string) {
+	v.timeline.addNotice(text)
+	v.renderTimeline()
+}
+
+func (v *chatView) completions(word string) []string {
+	if strings.HasPrefix(word, "/") && v.completer.head == "" {
+		return commandCompletions(word)
+	}
+
+	if strings.HasPrefix(word, "@") {
+		var matches []string
+		for _, u := range v.client.UsersOnline() {
+			if strings.HasPrefix("@"+u, word) {
+				matches = append(matches, "@"+u)
+			}
+		}
+		return matches
+	}
+
+	return nil
+}
+
+// showMentions lists the messages that mentioned the user.
+func (v *chatView) showMentions(ml *packets.MentionList) {
+	text := tview.NewTextView().SetDynamicColors(true)
+	if len(ml.Messages) == 0 {
+		text.SetText("[grey]Nobody mentioned you yet")
+	}
+	for _, m := range ml.Messages {
+		text.Write(chatViewMsgFormat(&m, renderMarkdown(m.Payload), v.timeline.timeFormat))
+	}
+	text.ScrollToEnd()
+	text.SetDoneFunc(func(tcell.Key) { v.closeModal("mentions") })
+	text.SetBorder(true).SetTitle("Mentions (Esc to close)")
+
+	v.showModal("mentions", text, 80, 20)
+}
+
+// chatViewMsgFormat formats a message whose payload was rendered to text.
+func chatViewMsgFormat(m *packets.Message, text string, timeFormat string) []byte {
+	return []byte("[blue]" + formatTime(m.Time(), timeFormat) + " [yellow]" + tview.Escape(m.From) + "[white]: " + text + editedMarker(m) + "\n")
+}
+
+func chatViewOwnMsgFormat(m *packets.Message, text string, timeFormat string) []byte {
+	return []byte("[blue]" + formatTime(m.Time(), timeFormat) + " [yellow]Me" + "[white]: " + text + editedMarker(m) + "\n")
+}
+
+// formatTime shows t in the local time zone, escaped since layouts can hold
+// square brackets.
+func formatTime(t time.Time, layout string) string {
+	return tview.Escape(t.Local().Format(layout))
+}
+
+func editedMarker(m *packets.Message) string {
+	if m.Edited {
+		return " [grey](edited)[white]"
+	}
+	return ""
 }
@@ -0,0 +1,221 @@
+package client
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/tview"
+)
+
+const (
+	// codeStyle is the style of inline code and code blocks, reset by
+	// codeReset.
+	codeStyle = "[#e4e4e4:#3a3a3a]"
+	codeReset = "[-:-]"
+	// linkStyle is the style of link texts, followed by the URL the terminal
+	// opens when it supports hyperlinks.
+	linkStyle = "[#5fafff::u:"
+	linkReset = "[-::-:-]"
+	// maxMarkdownLength is the longest text formatted, longer ones being
+	// shown as they were typed.
+	maxMarkdownLength = 16 << 10
+)
+
+// linkPattern matches an inline link such as [the docs](https://example.com).
+var linkPattern = regexp.MustCompile(`^\[([^\[\]]+)\]\((https?://[^()\s\[\]]+)\)`)
+
+// renderMarkdown formats the payload of a message as tview dynamic-colour
+// text. It supports a small markdown subset: **bold**, *italic* or _italic_,
+// `code`, [links](https://example.com) and ``` fenced code blocks. Anything
+// else, tview tags included, is shown as it was typed.
+func renderMarkdown(text string) string {
+	if len(text) > maxMarkdownLength {
+		return tview.Escape(text)
+	}
+
+	var lines []string
+	inBlock := false
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") && !isInlineCode(line) {
+			// A code block starts on a line of its own, below the sender
+			if !inBlock && len(lines) == 0 {
+				lines = append(lines, "")
+			}
+			inBlock = !inBlock
+			continue
+		}
+
+		if inBlock {
+			lines = append(lines, codeStyle+" "+tview.Escape(line)+" "+codeReset)
+		} else {
+			lines = append(lines, renderInline(line))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// isInlineCode reports whether a line starting with a fence is a single line
+// of code such as ```x := 1```, rather than the start of a block.
+func isInlineCode(line string) bool {
+	line = strings.TrimSpace(line)
+	return len(line) > 6 && strings.HasSuffix(line, "```")
+}
+
+// renderInline formats a line outside of code blocks.
+func renderInline(line string) string {
+	var out, plain strings.Builder
+	var bold bool
+	// italic is the delimiter that opened the italic text, if any.
+	var italic byte
+	// Looking ahead once per line keeps long lines of delimiters linear
+	closers := lastClosers(line)
+	links := strings.LastIndex(line, "](")
+	// missingFences are the lengths of backtick runs that do not appear
+	// further on the line.
+	missingFences := make(map[int]bool)
+
+	flush := func() {
+		out.WriteString(tview.Escape(plain.String()))
+		plain.Reset()
+	}
+	style := func() {
+		flush()
+		attributes := ""
+		if bold {
+			attributes += "b"
+		}
+		if italic != 0 {
+			attributes += "i"
+		}
+		if attributes == "" {
+			attributes = "-"
+		}
+		out.WriteString("[::" + attributes + "]")
+	}
+
+	for i := 0; i < len(line); {
+		switch c := line[i]; {
+		case c == '\\' && i+1 < len(line) && strings.IndexByte("\\`*_[]", line[i+1]) >= 0:
+			plain.WriteByte(line[i+1])
+			i += 2
+			continue
+		case c == '`':
+			n := len(line[i:]) - len(strings.TrimLeft(line[i:], "`"))
+			fence := strings.Repeat("`", n)
+			if !missingFences[n] {
+				if end := strings.Index(line[i+n:], fence); end > 0 {
+					flush()
+					out.WriteString(codeStyle + tview.Escape(line[i+n:i+n+end]) + codeReset)
+					i += 2*n + end
+					continue
+				}
+				missingFences[n] = true
+			}
+			plain.WriteString(fence)
+			i += n
+			continue
+		case strings.HasPrefix(line[i:], "**"):
+			if bold && closes(line, i, "**") || !bold && opens(line, i, "**", closers["**"]) {
+				bold = !bold
+				style()
+				i += 2
+				continue
+			}
+		case c == '*' || c == '_':
+			if italic == c && closes(line, i, string(c)) || italic == 0 && opens(line, i, string(c), closers[string(c)]) {
+				if italic == 0 {
+					italic = c
+				} else {
+					italic = 0
+				}
+				style()
+				i++
+				continue
+			}
+		case c == '[' && i < links:
+			if m := linkPattern.FindStringSubmatch(line[i:]); m != nil {
+				flush()
+				out.WriteString(linkStyle + m[2] + "]" + tview.Escape(m[1]) + linkReset)
+				if bold || italic != 0 {
+					style()
+				}
+				i += len(m[0])
+				continue
+			}
+		}
+
+		plain.WriteByte(line[i])
+		i++
+	}
+
+	flush()
+	if bold || italic != 0 {
+		out.WriteString("[::-]")
+	}
+
+	return out.String()
+}
+
+// lastClosers returns the position of the last delimiter of each kind that
+// can end emphasis on the line, or -1 if there is none.
+func lastClosers(line string) map[string]int {
+	last := make(map[string]int)
+	for _, delim := range []string{"**", "*", "_"} {
+		last[delim] = -1
+		for j := len(line) - len(delim); j > 0; j-- {
+			if strings.HasPrefix(line[j:], delim) && closes(line, j, delim) {
+				last[delim] = j
+				break
+			}
+		}
+	}
+	return last
+}
+
+// opens reports whether the delimiter at line[i:] starts emphasis: it is
+// followed by text and closed later on the line, where the last closer is.
+func opens(line string, i int, delim string, last int) bool {
+	after := i + len(delim)
+	if after >= len(line) || line[after] == ' ' || !flanked(line, i, delim) {
+		return false
+	}
+
+	// Underscores inside words, as in snake_case, are not emphasis
+	if delim == "_" && i > 0 && isWordByte(line, i-1) {
+		return false
+	}
+
+	return last > after
+}
+
+// closes reports whether the delimiter at line[i:] ends emphasis: it follows
+// text and, for underscores, is not followed by a word.
+func closes(line string, i int, delim string) bool {
+	if i == 0 || line[i-1] == ' ' || !flanked(line, i, delim) {
+		return false
+	}
+
+	after := i + len(delim)
+	return delim != "_" || after >= len(line) || !isWordByte(line, after)
+}
+
+// flanked reports whether a single-character delimiter at line[i] stands
+// alone, so that the stars of ** are not taken for italics.
+func flanked(line string, i int, delim string) bool {
+	if len(delim) != 1 {
+		return true
+	}
+	return (i == 0 || line[i-1] != delim[0]) && (i+1 >= len(line) || line[i+1] != delim[0])
+}
+
+func isWordByte(line string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(line[i:])
+	if r == utf8.RuneError {
+		// Inside a multi-byte character, look back for its start
+		r, _ = utf8.DecodeLastRuneInString(line[:i+1])
+	}
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "plain", text: "hello", want: "hello"},
+		{name: "tags", text: "[red]alert[::b] [\"m1\"]", want: "[red[]alert[::b[] [\"m1\"[]"},
+		{name: "bold", text: "a **bold** move", want: "a [::b]bold[::-] move"},
+		{name: "italic", text: "*very* _much_", want: "[::i]very[::-] [::i]much[::-]"},
+		{name: "nested", text: "**bold *and* italic**", want: "[::b]bold [::bi]and[::b] italic[::-]"},
+		{name: "unclosed", text: "2 * 3 and **maybe", want: "2 * 3 and **maybe"},
+		{name: "snake case", text: "call do_this_now", want: "call do_this_now"},
+		{name: "escaped", text: `\*not italic\*`, want: "*not italic*"},
+		{name: "code", text: "run `go [red]test`", want: "run " + codeStyle + "go [red[]test" + codeReset},
+		{name: "code with backticks", text: "``a ` b``", want: codeStyle + "a ` b" + codeReset},
+		{name: "no emphasis in code", text: "`**x**`", want: codeStyle + "**x**" + codeReset},
+		{
+			name: "link",
+			text: "see [the docs](https://example.com/a)",
+			want: "see " + linkStyle + "https://example.com/a]the docs" + linkReset,
+		},
+		{name: "not a link", text: "[x](javascript:alert)", want: "[x[](javascript:alert)"},
+		{
+			name: "code block",
+			text: "```go\nfmt.Println(\"[red]\")\n```\n*done*",
+			want: "\n" + codeStyle + " fmt.Println(\"[red[]\") " + codeReset + "\n[::i]done[::-]",
+		},
+		{name: "unterminated block", text: "look:\n```\nx", want: "look:\n" + codeStyle + " x " + codeReset},
+		{name: "unclosed runs", text: "*a `b *c", want: "*a `b *c"},
+		{name: "too long", text: strings.Repeat("*a ", maxMarkdownLength) + "[x]", want: strings.Repeat("*a ", maxMarkdownLength) + "[x[]"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, renderMarkdown(tt.text), tt.name)
+	}
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// reactionEmojis are offered by the reaction picker.
+var reactionEmojis = []string{"👍", "👎", "😄", "🎉", "❤️", "👀", "🚀"}
+
+// reactions maps emojis to the users who reacted with them on one message.
+type reactions map[string][]string
+
+func (r reactions) apply(username string, emoji string, add bool) {
+	users := slices.DeleteFunc(r[emoji], func(u string) bool { return u == username })
+	if add {
+		users = append(users, username)
+	}
+
+	if len(users) == 0 {
+		delete(r, emoji)
+	} else {
+		r[emoji] = users
+	}
+}
+
+// render formats the reaction counts, highlighting the ones from me.
+func (r reactions) render(me string) string {
+	var parts []string
+	for _, emoji := range slices.Sorted(maps.Keys(r)) {
+		color := "white"
+		if slices.Contains(r[emoji], me) {
+			color = "green"
+		}
+		parts = append(parts, fmt.Sprintf("[%s]%s %d[white]", color, emoji, len(r[emoji])))
+	}
+	return strings.Join(parts, "  ")
+}
+
+// reactionTarget returns the message a reaction applies to: the selected one,
+// or else the newest message.
+func (v *chatView) reactionTarget() uint64 {
+	if v.selected != 0 {
+		return v.selected
+	}
+	return v.timeline.latestID()
+}
+
+// toggleReaction adds my reaction with emoji to a message, or removes it if
+// it is already there.
+func (v *chatView) toggleReaction(id uint64, emoji string) {
+	add := !slices.Contains(v.timeline.reactions[id][emoji], v.client.Name())
+	if err := v.client.React(id, emoji, add); err != nil {
+		v.systemMessage("Failed to react: " + err.Error())
+	}
+}
+
+func (v *chatView) react(args string) {
+	id := v.reactionTarget()
+	if id == 0 {
+		v.systemMessage("There is no message to react to")
+		return
+	}
+
+	if args == "" {
+		v.showReactionPicker()
+		return
+	}
+
+	v.toggleReaction(id, args)
+}
+
+// showReactionPicker opens a list of emojis to react to the selected message
+// with.
+func (v *chatView) showReactionPicker() {
+	id := v.reactionTarget()
+	if id == 0 {
+		return
+	}
+
+	list := tview.NewList().ShowSecondaryText(false)
+	for _, emoji := range reactionEmojis {
+		list.AddItem(emoji, "", 0, func() {
+			v.closeModal("reactions")
+			v.toggleReaction(id, emoji)
+		})
+	}
+	list.SetDoneFunc(func() { v.closeModal("reactions") })
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyEscape {
+			v.closeModal("reactions")
+			return nil
+		}
+		return event
+	})
+	list.SetBorder(true).SetTitle("React with")
+
+	v.showModal("reactions", list, 20, len(reactionEmojis)+2)
+}
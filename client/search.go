@@ -0,0 +1,269 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/root-man/chat/packets"
+)
+
+// searchPageSize is the number of results requested per page.
+const searchPageSize = 20
+
+// searchTimeLayouts are the accepted formats of the since and until filters,
+// in local time.
+var searchTimeLayouts = []string{time.DateOnly, "2006-01-02 15:04", time.DateTime}
+
+// searchFields are the filters of a search as the user typed them.
+type searchFields struct {
+	query string
+	from  string
+	since string
+	until string
+}
+
+// parseSearchArgs reads the arguments of /search: words of the query mixed
+// with from:<user>, since:<date> and until:<date> filters.
+func parseSearchArgs(args string) searchFields {
+	var fields searchFields
+	var query []string
+	for _, word := range strings.Fields(args) {
+		key, value, _ := strings.Cut(word, ":")
+		switch {
+		case key == "from" && value != "":
+			fields.from = strings.TrimPrefix(value, "@")
+		case key == "since" && value != "":
+			fields.since = value
+		case key == "until" && value != "":
+			fields.until = value
+		default:
+			query = append(query, word)
+		}
+	}
+
+	fields.query = strings.Join(query, " ")
+	return fields
+}
+
+// request builds the search request for the first page of results.
+func (f searchFields) request() (*packets.SearchRequest, error) {
+	since, err := parseSearchTime(f.since, false)
+	if err != nil {
+		return nil, err
+	}
+
+	until, err := parseSearchTime(f.until, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &packets.SearchRequest{
+		Query: f.query,
+		From:  f.from,
+		Since: since,
+		Until: until,
+		Limit: searchPageSize,
+	}, nil
+}
+
+// parseSearchTime parses a since or until filter. A date alone stands for the
+// start of that day, or for the end of it when it is an until filter.
+func parseSearchTime(value string, until bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	for _, layout := range searchTimeLayouts {
+		t, err := time.ParseInLocation(layout, value, time.Local)
+		if err != nil {
+			continue
+		}
+
+		if until && layout == time.DateOnly {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD [HH:MM]", value)
+}
+
+// searchDialog is the search window: the filters, the current page of results
+// and the request that produced it.
+type searchDialog struct {
+	form    *tview.Form
+	status  *tview.TextView
+	results *tview.List
+	request *packets.SearchRequest
+	hits    []packets.Message
+	total   uint32
+}
+
+// search opens the search dialog, running the search right away when /search
+// is given arguments.
+func (v *chatView) search(args string) {
+	fields := parseSearchArgs(args)
+	v.showSearchDialog(fields)
+
+	if args != "" {
+		v.runSearch(fields, 0)
+	}
+}
+
+func (v *chatView) showSearchDialog(fields searchFields) {
+	if v.searchDialog != nil {
+		v.closeModal("search")
+	}
+
+	d := &searchDialog{}
+	v.searchDialog = d
+
+	d.form = tview.NewForm().
+		AddInputField("Query", fields.query, 0, nil, nil).
+		AddInputField("From", fields.from, 32, nil, nil).
+		AddInputField("Since", fields.since, 16, nil, nil).
+		AddInputField("Until", fields.until, 16, nil, nil).
+		AddButton("Search", func() { v.runSearch(d.fields(), 0) }).
+		AddButton("Newer", func() { v.searchPage(-1) }).
+		AddButton("Older", func() { v.searchPage(1) }).
+		AddButton("Close", v.closeSearch).
+		SetItemPadding(0).
+		SetCancelFunc(v.closeSearch)
+	d.form.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		// Enter in any of the fields runs the search
+		if item, _ := d.form.GetFocusedItemIndex(); item >= 0 && event.Key() == tcell.KeyEnter {
+			v.runSearch(d.fields(), 0)
+			return nil
+		}
+		return event
+	})
+
+	d.status = tview.NewTextView().SetDynamicColors(true).
+		SetText("[grey]" + tview.Escape("Dates are YYYY-MM-DD [HH:MM], Enter to search, Tab to move"))
+
+	d.results = tview.NewList().SetSelectedFunc(func(i int, _ string, _ string, _ rune) {
+		v.jumpTo(d.hits[i])
+	})
+	d.results.SetDoneFunc(v.closeSearch)
+	d.results.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyTab || event.Key() == tcell.KeyBacktab {
+			v.app.SetFocus(d.form)
+			return nil
+		}
+		return event
+	})
+
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(d.form, 8, 0, true).
+		AddItem(d.status, 1, 0, false).
+		AddItem(d.results, 0, 1, false)
+	layout.SetBorder(true).SetTitle("Search (Esc to close)")
+
+	v.showModal("search", layout, 90, 30)
+}
+
+func (d *searchDialog) fields() searchFields {
+	text := func(label string) string {
+		return strings.TrimSpace(d.form.GetFormItemByLabel(label).(*tview.InputField).GetText())
+	}
+
+	return searchFields{query: text("Query"), from: text("From"), since: text("Since"), until: text("Until")}
+}
+
+// runSearch requests the page of results starting at offset.
+func (v *chatView) runSearch(fields searchFields, offset uint32) {
+	d := v.searchDialog
+	req, err := fields.request()
+	if err != nil {
+		d.status.SetText("[red]" + tview.Escape(err.Error()))
+		return
+	}
+
+	req.Offset = offset
+	if err := v.client.Search(req); err != nil {
+		d.status.SetText("[red]Failed to search: " + tview.Escape(err.Error()))
+		return
+	}
+
+	d.request = req
+	d.status.SetText("[grey]Searching...")
+}
+
+// searchPage moves to the page of newer (-1) or older (1) results.
+func (v *chatView) searchPage(direction int) {
+	d := v.searchDialog
+	if d.request == nil {
+		return
+	}
+
+	offset := int(d.request.Offset) + direction*int(d.request.Limit)
+	if offset < 0 || offset >= int(d.total) {
+		return
+	}
+
+	req := *d.request
+	req.Offset = uint32(offset)
+	if err := v.client.Search(&req); err != nil {
+		d.status.SetText("[red]Failed to search: " + tview.Escape(err.Error()))
+		return
+	}
+	d.request = &req
+}
+
+// showSearchResults lists a page of results in the search dialog, if it is
+// still open.
+func (v *chatView) showSearchResults(sr *packets.SearchResults) {
+	d := v.searchDialog
+	if d == nil {
+		return
+	}
+
+	d.hits = sr.Messages
+	d.total = sr.Total
+	d.results.Clear()
+
+	if len(sr.Messages) == 0 {
+		d.status.SetText("[grey]No results")
+		return
+	}
+
+	for _, m := range sr.Messages {
+		text := "[yellow]" + tview.Escape(m.From) + "[white]: " + tview.Escape(m.Payload)
+		d.results.AddItem(text, "[blue]"+formatTime(m.Time(), v.timeline.timeFormat), 0, nil)
+	}
+
+	d.status.SetText(fmt.Sprintf("[grey]Results %d-%d of %d, Enter to jump, Older/Newer for more",
+		sr.Offset+1, int(sr.Offset)+len(sr.Messages), sr.Total))
+	v.app.SetFocus(d.results)
+}
+
+func (v *chatView) closeSearch() {
+	v.searchDialog = nil
+	v.closeModal("search")
+}
+
+// jumpTo shows a search hit in the chat window, loading it into the timeline
+// if it is not there. A reply opens the thread of its parent.
+func (v *chatView) jumpTo(m packets.Message) {
+	v.closeSearch()
+
+	if v.timeline.find(m.ID) == nil {
+		v.timeline.insert(&m)
+	}
+
+	target := m.ID
+	if m.ParentID != 0 && v.timeline.find(m.ParentID) != nil {
+		target = m.ParentID
+		if v.thread != 0 {
+			v.toggleThread("")
+		}
+		v.selected = target
+		v.toggleThread("")
+	}
+
+	v.renderTimeline()
+	v.selectMessage(target)
+}
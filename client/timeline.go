@@ -0,0 +1,353 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/rivo/tview"
+	"github.com/root-man/chat/packets"
+)
+
+// maxTimelineEntries bounds the number of lines kept in the chat window.
+const maxTimelineEntries = 1000
+
+// timeline is the model behind the chat window: the messages and notices it
+// shows, where the unread messages start and how far the other users have
+// read.
+type timeline struct {
+	entries []timelineEntry
+	// unreadFrom is the ID of the first message that was unread on login.
+	unreadFrom uint64
+	replayed   bool
+	readBy     map[string]uint64
+	reactions  map[uint64]reactions
+	// rendered caches the rendered payloads by message ID, as every packet
+	// renders the whole timeline again.
+	rendered map[uint64]string
+	// timeFormat is the layout message times are shown with.
+	timeFormat string
+}
+
+type timelineEntry struct {
+	msg     *packets.Message
+	notice  string
+	deleted bool
+}
+
+func newTimeline(timeFormat string) *timeline {
+	return &timeline{readBy: make(map[string]uint64), reactions: make(map[uint64]reactions), rendered: make(map[uint64]string), timeFormat: timeFormat}
+}
+
+func (t *timeline) addMessage(m *packets.Message) {
+	t.add(timelineEntry{msg: m})
+}
+
+func (t *timeline) addNotice(text string) {
+	t.add(timelineEntry{notice: text})
+}
+
+func (t *timeline) add(e timelineEntry) {
+	t.entries = append(t.entries, e)
+	if overflow := len(t.entries) - maxTimelineEntries; overflow > 0 {
+		for _, e := range t.entries[:overflow] {
+			if e.msg != nil {
+				delete(t.reactions, e.msg.ID)
+				delete(t.rendered, e.msg.ID)
+			}
+		}
+		t.entries = t.entries[overflow:]
+	}
+}
+
+// insert adds a message that is older than the newest one, such as a search
+// hit, at its place in ID order.
+func (t *timeline) insert(m *packets.Message) {
+	i := slices.IndexFunc(t.entries, func(e timelineEntry) bool { return e.msg != nil && e.msg.ID > m.ID })
+	if i < 0 {
+		t.addMessage(m)
+		return
+	}
+	t.entries = slices.Insert(t.entries, i, timelineEntry{msg: m})
+}
+
+func (t *timeline) clear() {
+	t.entries = nil
+	t.unreadFrom = 0
+	t.reactions = make(map[uint64]reactions)
+	t.rendered = make(map[uint64]string)
+}
+
+// find returns the message with the given ID, or nil if it is not shown.
+func (t *timeline) find(id uint64) *timelineEntry {
+	for i := range t.entries {
+		if m := t.entries[i].msg; m != nil && m.ID == id {
+			return &t.entries[i]
+		}
+	}
+	return nil
+}
+
+func (t *timeline) edit(id uint64, payload string) {
+	if e := t.find(id); e != nil {
+		edited := *e.msg
+		edited.Payload = payload
+		edited.Edited = true
+		e.msg = &edited
+		delete(t.rendered, id)
+	}
+}
+
+func (t *timeline) delete(id uint64) {
+	if e := t.find(id); e != nil {
+		e.deleted = true
+	}
+	delete(t.reactions, id)
+}
+
+func (t *timeline) react(id uint64, username string, emoji string, add bool) {
+	if t.reactions[id] == nil {
+		t.reactions[id] = make(reactions)
+	}
+	t.reactions[id].apply(username, emoji, add)
+}
+
+// neighbour returns the ID of the message shown in the main timeline before
+// (offset -1) or after (offset 1) the message with the given ID. An ID of 0 stands for the
+// position past the newest message. It returns 0 when moving past the end.
+func (t *timeline) neighbour(id uint64, offset int) uint64 {
+	known := t.known()
+	var ids []uint64
+	for _, e := range t.entries {
+		if e.msg != nil && e.msg.ID != 0 && !e.deleted && !inThread(e.msg, known) {
+			ids = append(ids, e.msg.ID)
+		}
+	}
+
+	i := slices.Index(ids, id)
+	if i < 0 {
+		i = len(ids)
+	}
+
+	i += offset
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(ids) {
+		return 0
+	}
+
+	return ids[i]
+}
+
+// lastFrom returns the ID of the newest message sent by username.
+func (t *timeline) lastFrom(username string) uint64 {
+	for i := len(t.entries) - 1; i >= 0; i-- {
+		if e := t.entries[i]; e.msg != nil && e.msg.ID != 0 && !e.deleted && e.msg.From == username {
+			return e.msg.ID
+		}
+	}
+	return 0
+}
+
+// latestID returns the ID of the newest message, or 0 if there is none.
+func (t *timeline) latestID() uint64 {
+	for i := len(t.entries) - 1; i >= 0; i-- {
+		if m := t.entries[i].msg; m != nil && m.ID != 0 {
+			return m.ID
+		}
+	}
+	return 0
+}
+
+// replayDone places the unread divider before the first replayed message that
+// is newer than the user's read position and was not sent by them.
+func (t *timeline) replayDone(lastRead uint64, me string) {
+	t.replayed = true
+	for _, e := range t.entries {
+		if e.msg != nil && e.msg.ID > lastRead && e.msg.From != me {
+			t.unreadFrom = e.msg.ID
+			return
+		}
+	}
+}
+
+func (t *timeline) setReadBy(username string, messageID uint64) {
+	t.readBy[username] = max(t.readBy[username], messageID)
+}
+
+func (t *timeline) rename(from string, to string) {
+	if position, ok := t.readBy[from]; ok {
+		delete(t.readBy, from)
+		t.setReadBy(to, position)
+	}
+}
+
+// render formats the main timeline as tview dynamic-colour text. Replies are
+// left to the thread pane, unless their parent is not known.
+func (t *timeline) render(me string) []byte {
+	var buf bytes.Buffer
+	replies := t.replyCounts()
+	known := t.known()
+
+	var shown []timelineEntry
+	for _, e := range t.entries {
+		if e.msg == nil || !inThread(e.msg, known) {
+			shown = append(shown, e)
+		}
+	}
+
+	var latest uint64
+	for _, e := range shown {
+		if e.msg != nil && e.msg.ID != 0 {
+			latest = e.msg.ID
+		}
+	}
+
+	for _, e := range shown {
+		if e.msg == nil {
+			buf.WriteString("[grey]" + tview.Escape(e.notice) + "[white]\n")
+			continue
+		}
+
+		if e.msg.ID != 0 && e.msg.ID == t.unreadFrom {
+			buf.WriteString("[red]──────── new messages ────────[white]\n")
+		}
+
+		t.renderMessage(&buf, e, me, replies[e.msg.ID])
+
+		if e.msg.ID != 0 && e.msg.ID == latest {
+			if seenBy := t.seenBy(e.msg, me); len(seenBy) > 0 {
+				buf.WriteString("[grey]  seen by " + tview.Escape(strings.Join(seenBy, ", ")) + "[white]\n")
+			}
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// renderThread formats the message with the given ID followed by its replies.
+func (t *timeline) renderThread(id uint64, me string) []byte {
+	var buf bytes.Buffer
+
+	parent := t.find(id)
+	if parent == nil {
+		return []byte("[grey]This thread is no longer available[white]\n")
+	}
+
+	t.renderMessage(&buf, *parent, me, 0)
+
+	var replies []timelineEntry
+	for _, e := range t.entries {
+		if e.msg != nil && e.msg.ParentID == id {
+			replies = append(replies, e)
+		}
+	}
+
+	fmt.Fprintf(&buf, "[grey]──────── %d replies ────────[white]\n", len(replies))
+	for _, e := range replies {
+		t.renderMessage(&buf, e, me, 0)
+	}
+
+	return buf.Bytes()
+}
+
+// renderMessage writes a message with its reply-count badge and reactions.
+func (t *timeline) renderMessage(buf *bytes.Buffer, e timelineEntry, me string, replies int) {
+	if e.msg.ID != 0 {
+		fmt.Fprintf(buf, `["%s"]`, messageRegion(e.msg.ID))
+	}
+
+	var line []byte
+	switch {
+	case e.deleted:
+		line = []byte("[grey](message deleted)[white]\n")
+	case e.msg.From == me:
+		line = chatViewOwnMsgFormat(e.msg, t.markdown(e.msg), t.timeFormat)
+	default:
+		line = chatViewMsgFormat(e.msg, t.markdown(e.msg), t.timeFormat)
+	}
+
+	if replies > 0 {
+		line = append(bytes.TrimSuffix(line, []byte("\n")), fmt.Sprintf(" [aqua][%d replies][white]\n", replies)...)
+	}
+
+	if !e.deleted && mentions(e.msg, me) {
+		line = append([]byte("[:maroon]"), append(bytes.TrimSuffix(line, []byte("\n")), "[:-]\n"...)...)
+	}
+	buf.Write(line)
+
+	if e.msg.ID != 0 {
+		buf.WriteString(`[""]`)
+	}
+
+	if r := t.reactions[e.msg.ID]; len(r) > 0 && !e.deleted {
+		buf.WriteString("  " + r.render(me) + "\n")
+	}
+}
+
+// markdown returns the rendered payload of a message.
+func (t *timeline) markdown(m *packets.Message) string {
+	if m.ID == 0 {
+		return renderMarkdown(m.Payload)
+	}
+
+	text, ok := t.rendered[m.ID]
+	if !ok {
+		text = renderMarkdown(m.Payload)
+		t.rendered[m.ID] = text
+	}
+	return text
+}
+
+// mentions reports whether m, sent by someone else, mentions me.
+func mentions(m *packets.Message, me string) bool {
+	return m.From != me && slices.Contains(packets.Mentions(m.Payload), me)
+}
+
+// inThread reports whether m is a reply shown in the thread pane of a known
+// parent rather than in the main timeline.
+func inThread(m *packets.Message, known map[uint64]bool) bool {
+	return m.ParentID != 0 && known[m.ParentID]
+}
+
+// known returns the IDs of the messages in the timeline.
+func (t *timeline) known() map[uint64]bool {
+	ids := make(map[uint64]bool, len(t.entries))
+	for _, e := range t.entries {
+		if e.msg != nil {
+			ids[e.msg.ID] = true
+		}
+	}
+	return ids
+}
+
+// replyCounts returns the number of replies to each message.
+func (t *timeline) replyCounts() map[uint64]int {
+	counts := make(map[uint64]int)
+	for _, e := range t.entries {
+		if e.msg != nil && e.msg.ParentID != 0 && !e.deleted {
+			counts[e.msg.ParentID]++
+		}
+	}
+	return counts
+}
+
+// messageRegion returns the name of the chat window region a message is
+// rendered in, used to highlight it.
+func messageRegion(id uint64) string {
+	return fmt.Sprintf("m%d", id)
+}
+
+// seenBy returns the users other than the author and me who have read m.
+func (t *timeline) seenBy(m *packets.Message, me string) []string {
+	var users []string
+	for _, u := range slices.Sorted(maps.Keys(t.readBy)) {
+		if u != me && u != m.From && t.readBy[u] >= m.ID {
+			users = append(users, u)
+		}
+	}
+	return users
+}
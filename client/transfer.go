@@ -0,0 +1,475 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/root-man/chat/packets"
+)
+
+// transfers tracks the files the user sends and receives. It is only used
+// from the interface goroutine, uploads reporting their progress through it.
+type transfers struct {
+	dir string
+	// pending are the offers waiting for the server to assign their ID.
+	pending   []*upload
+	uploads   map[uint64]*upload
+	downloads map[uint64]*download
+	// offers are the files offered to the user and not accepted yet.
+	offers map[uint64]*packets.FileOffer
+	// accepted are the stored files requested with /accept without having
+	// seen their offer, which the server sends before the file.
+	accepted map[uint64]bool
+}
+
+type upload struct {
+	path    string
+	offer   packets.FileOffer
+	started bool
+	sent    uint64
+	// cancel is closed to stop the upload.
+	cancel chan struct{}
+}
+
+type download struct {
+	offer    packets.FileOffer
+	file     *os.File
+	hash     hash.Hash
+	received uint64
+}
+
+func newTransfers(dir string) *transfers {
+	return &transfers{
+		dir:       dir,
+		uploads:   make(map[uint64]*upload),
+		downloads: make(map[uint64]*download),
+		offers:    make(map[uint64]*packets.FileOffer),
+		accepted:  make(map[uint64]bool),
+	}
+}
+
+// String summarises the progress of the running transfers.
+func (t *transfers) String() string {
+	var progress []string
+	for _, id := range slices.Sorted(maps.Keys(t.uploads)) {
+		if u := t.uploads[id]; u.started {
+			progress = append(progress, fmt.Sprintf("↑ %s %d%%", u.offer.Name, percent(u.sent, u.offer.Size)))
+		}
+	}
+
+	for _, id := range slices.Sorted(maps.Keys(t.downloads)) {
+		d := t.downloads[id]
+		progress = append(progress, fmt.Sprintf("↓ %s %d%%", d.offer.Name, percent(d.received, d.offer.Size)))
+	}
+
+	return strings.Join(progress, "  ")
+}
+
+func percent(done uint64, total uint64) uint64 {
+	if total == 0 {
+		return 100
+	}
+	return done * 100 / total
+}
+
+// fileOffer describes the file at path, reading it once to compute its
+// checksum.
+func fileOffer(path string, to string) (*packets.FileOffer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+
+	offer := &packets.FileOffer{To: to, Name: filepath.Base(path), Size: uint64(info.Size())}
+	h.Sum(offer.Checksum[:0])
+	return offer, nil
+}
+
+// uniquePath returns a path in dir for a file named name that does not
+// overwrite an existing file, numbering the name if needed.
+func uniquePath(dir string, name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	path := filepath.Join(dir, name)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			return path
+		}
+		path = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+	}
+}
+
+// formatSize formats a number of bytes for humans.
+func formatSize(size uint64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := uint64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// sendFile offers a file to a user, or to the room: /send [@user] <path>.
+func (v *chatView) sendFile(args string) {
+	var to string
+	if strings.HasPrefix(args, "@") {
+		to, args, _ = strings.Cut(args[1:], " ")
+		args = strings.TrimSpace(args)
+	}
+
+	if args == "" {
+		v.systemMessage("Usage: " + commands["send"].usage)
+		return
+	}
+
+	// Hashing a large file takes a while, keep the interface responsive
+	go func() {
+		offer, err := fileOffer(args, to)
+		v.app.QueueUpdateDraw(func() {
+			if err != nil {
+				v.systemMessage("Cannot send file: " + err.Error())
+				return
+			}
+
+			u := &upload{path: args, offer: *offer, cancel: make(chan struct{})}
+			if err := v.client.OfferFile(offer); err != nil {
+				v.systemMessage("Failed to offer file: " + err.Error())
+				return
+			}
+			v.transfers.pending = append(v.transfers.pending, u)
+		})
+	}()
+}
+
+// acceptFile downloads an offered or stored file: /accept <id>.
+func (v *chatView) acceptFile(args string) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(args, "#"), 10, 64)
+	if err != nil {
+		v.systemMessage("Usage: " + commands["accept"].usage)
+		return
+	}
+
+	if _, ok := v.transfers.downloads[id]; ok {
+		v.systemMessage(fmt.Sprintf("File #%d is already being downloaded", id))
+		return
+	}
+
+	if offer, ok := v.transfers.offers[id]; ok {
+		if err := v.startDownload(offer); err != nil {
+			v.systemMessage("Cannot download file: " + err.Error())
+			return
+		}
+	} else {
+		v.transfers.accepted[id] = true
+	}
+
+	if err := v.client.AcceptFile(id); err != nil {
+		v.systemMessage("Failed to accept file: " + err.Error())
+	}
+}
+
+// cancelFile stops an upload or a download, or declines an offer:
+// /cancel <id>.
+func (v *chatView) cancelFile(args string) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(args, "#"), 10, 64)
+	if err != nil {
+		v.systemMessage("Usage: " + commands["cancel"].usage)
+		return
+	}
+
+	t := v.transfers
+	switch {
+	case t.uploads[id] != nil:
+		v.client.CancelFile(id, "cancelled by the sender")
+		v.stopUpload(id)
+		v.systemMessage(fmt.Sprintf("Cancelled sending #%d", id))
+	case t.downloads[id] != nil:
+		v.client.CancelFile(id, "cancelled by the receiver")
+		v.stopDownload(id)
+		v.systemMessage(fmt.Sprintf("Cancelled downloading #%d", id))
+	case t.offers[id] != nil:
+		delete(t.offers, id)
+		v.systemMessage(fmt.Sprintf("Declined file #%d", id))
+	default:
+		v.systemMessage(fmt.Sprintf("There is no file transfer #%d", id))
+	}
+}
+
+func (v *chatView) handleFilePacket(p packets.Packet) {
+	t := v.transfers
+	switch p := p.(type) {
+	case *packets.FileOffer:
+		if _, ok := t.downloads[p.ID]; ok {
+			return
+		}
+
+		// A stored file is announced again when downloaded, even one of the
+		// user's own
+		if t.accepted[p.ID] {
+			delete(t.accepted, p.ID)
+			if err := v.startDownload(p); err != nil {
+				v.client.CancelFile(p.ID, "cannot save the file")
+				v.systemMessage("Cannot download file: " + err.Error())
+			}
+			return
+		}
+
+		if p.From == v.client.Name() {
+			v.offerAcknowledged(p)
+			return
+		}
+
+		t.offers[p.ID] = p
+		verb := "offers"
+		if p.To != "" {
+			verb = "wants to send you"
+		}
+		v.systemMessage(fmt.Sprintf("%s %s %s (%s), type /accept %d to download it", p.From, verb, p.Name, formatSize(p.Size), p.ID))
+	case *packets.FileAccept:
+		u, ok := t.uploads[p.ID]
+		if !ok || u.started {
+			return
+		}
+
+		u.started = true
+		if p.Username == systemUser {
+			v.systemMessage(fmt.Sprintf("Uploading %s to the server", u.offer.Name))
+		} else {
+			v.systemMessage(fmt.Sprintf("%s accepted %s", p.Username, u.offer.Name))
+		}
+		go v.upload(u)
+	case *packets.FileChunk:
+		d, ok := t.downloads[p.ID]
+		if !ok {
+			return
+		}
+
+		if p.Offset != d.received {
+			v.failDownload(p.ID, "chunks arrived out of order")
+			return
+		}
+
+		if _, err := d.file.Write(p.Data); err != nil {
+			v.failDownload(p.ID, err.Error())
+			return
+		}
+		d.hash.Write(p.Data)
+		d.received += uint64(len(p.Data))
+	case *packets.FileComplete:
+		v.finishDownload(p.ID)
+	case *packets.FileCancel:
+		v.transferCancelled(p)
+	}
+
+	v.renderTransfers()
+}
+
+// offerAcknowledged pairs an offer echoed by the server with the upload it
+// belongs to.
+func (v *chatView) offerAcknowledged(offer *packets.FileOffer) {
+	t := v.transfers
+	i := slices.IndexFunc(t.pending, func(u *upload) bool {
+		return u.offer.Name == offer.Name && u.offer.Size == offer.Size && u.offer.Checksum == offer.Checksum
+	})
+	if i < 0 {
+		return
+	}
+
+	u := t.pending[i]
+	t.pending = slices.Delete(t.pending, i, i+1)
+	u.offer = *offer
+	t.uploads[offer.ID] = u
+
+	v.systemMessage(fmt.Sprintf("Offered %s (%s) as #%d, type /cancel %d to take it back", offer.Name, formatSize(offer.Size), offer.ID, offer.ID))
+}
+
+// upload streams a file to the server chunk by chunk. It runs in its own
+// goroutine and reports back through the interface goroutine.
+func (v *chatView) upload(u *upload) {
+	id := u.offer.ID
+	err := func() error {
+		f, err := os.Open(u.path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		buf := make([]byte, packets.FileChunkSize)
+		for offset := uint64(0); offset < u.offer.Size; {
+			select {
+			case <-u.cancel:
+				return nil
+			default:
+			}
+
+			n, err := io.ReadFull(f, buf[:min(uint64(len(buf)), u.offer.Size-offset)])
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", u.path, err)
+			}
+
+			if err := v.client.SendFileChunk(id, offset, buf[:n]); err != nil {
+				return err
+			}
+
+			offset += uint64(n)
+			sent := offset
+			v.app.QueueUpdateDraw(func() {
+				u.sent = sent
+				v.renderTransfers()
+			})
+		}
+
+		select {
+		case <-u.cancel:
+			return nil
+		default:
+		}
+		return v.client.CompleteFile(id)
+	}()
+
+	v.app.QueueUpdateDraw(func() {
+		if _, ok := v.transfers.uploads[id]; !ok {
+			return
+		}
+
+		if err != nil {
+			v.client.CancelFile(id, "the upload failed")
+			v.systemMessage(fmt.Sprintf("Failed to send %s: %s", u.offer.Name, err))
+		} else {
+			v.systemMessage(fmt.Sprintf("Sent %s", u.offer.Name))
+		}
+		delete(v.transfers.uploads, id)
+		v.renderTransfers()
+	})
+}
+
+func (v *chatView) startDownload(offer *packets.FileOffer) error {
+	if err := os.MkdirAll(v.transfers.dir, 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(v.transfers.dir, "."+offer.Name+".*.part")
+	if err != nil {
+		return err
+	}
+
+	delete(v.transfers.offers, offer.ID)
+	v.transfers.downloads[offer.ID] = &download{offer: *offer, file: f, hash: sha256.New()}
+	v.systemMessage(fmt.Sprintf("Downloading %s, type /cancel %d to stop", offer.Name, offer.ID))
+	return nil
+}
+
+// finishDownload checks a received file against its offer and moves it to
+// the download directory.
+func (v *chatView) finishDownload(id uint64) {
+	d, ok := v.transfers.downloads[id]
+	if !ok {
+		return
+	}
+
+	if d.received != d.offer.Size || !bytes.Equal(d.hash.Sum(nil), d.offer.Checksum[:]) {
+		v.failDownload(id, "checksum mismatch")
+		return
+	}
+
+	// Temporary files are private, make it a regular download
+	if err := d.file.Chmod(0o644); err != nil {
+		v.failDownload(id, err.Error())
+		return
+	}
+
+	if err := d.file.Close(); err != nil {
+		v.failDownload(id, err.Error())
+		return
+	}
+
+	path := uniquePath(v.transfers.dir, d.offer.Name)
+	if err := os.Rename(d.file.Name(), path); err != nil {
+		v.failDownload(id, err.Error())
+		return
+	}
+
+	delete(v.transfers.downloads, id)
+	v.systemMessage(fmt.Sprintf("Saved %s to %s", d.offer.Name, path))
+}
+
+func (v *chatView) failDownload(id uint64, reason string) {
+	d := v.transfers.downloads[id]
+	v.client.CancelFile(id, reason)
+	v.stopDownload(id)
+	v.systemMessage(fmt.Sprintf("Failed to download %s: %s", d.offer.Name, reason))
+}
+
+// transferCancelled cleans up after a transfer cancelled by the server or the
+// sender.
+func (v *chatView) transferCancelled(cancel *packets.FileCancel) {
+	t := v.transfers
+	id := cancel.ID
+	switch {
+	case t.uploads[id] != nil:
+		u := t.uploads[id]
+		v.stopUpload(id)
+		v.systemMessage(fmt.Sprintf("Sending %s was cancelled: %s", u.offer.Name, cancel.Reason))
+	case t.downloads[id] != nil:
+		d := t.downloads[id]
+		v.stopDownload(id)
+		v.systemMessage(fmt.Sprintf("Downloading %s was cancelled: %s", d.offer.Name, cancel.Reason))
+	case t.offers[id] != nil:
+		offer := t.offers[id]
+		delete(t.offers, id)
+		v.systemMessage(fmt.Sprintf("%s is no longer available: %s", offer.Name, cancel.Reason))
+	case t.accepted[id]:
+		delete(t.accepted, id)
+		v.systemMessage(fmt.Sprintf("Cannot download file #%d: %s", id, cancel.Reason))
+	}
+}
+
+func (v *chatView) stopUpload(id uint64) {
+	if u := v.transfers.uploads[id]; u != nil {
+		close(u.cancel)
+		delete(v.transfers.uploads, id)
+	}
+}
+
+func (v *chatView) stopDownload(id uint64) {
+	if d := v.transfers.downloads[id]; d != nil {
+		d.file.Close()
+		os.Remove(d.file.Name())
+		delete(v.transfers.downloads, id)
+	}
+}
+
+func (v *chatView) renderTransfers() {
+	v.transferText.SetText(v.transfers.String())
+}
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileOffer(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "notes.txt")
+	require.NoError(t, os.WriteFile(path, []byte("some notes"), 0o644))
+
+	offer, err := fileOffer(path, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, "notes.txt", offer.Name)
+	assert.Equal(t, "bob", offer.To)
+	assert.Equal(t, uint64(10), offer.Size)
+	assert.Equal(t, sha256.Sum256([]byte("some notes")), offer.Checksum)
+
+	_, err = fileOffer(dir, "")
+	assert.Error(t, err, "directories cannot be sent")
+
+	assert.Equal(t, filepath.Join(dir, "notes (1).txt"), uniquePath(dir, "notes.txt"))
+	assert.Equal(t, filepath.Join(dir, "other.txt"), uniquePath(dir, "other.txt"))
+}
+
+func TestFormatSize(t *testing.T) {
+	assert.Equal(t, "512 B", formatSize(512))
+	assert.Equal(t, "1.5 KiB", formatSize(1536))
+	assert.Equal(t, "64.0 MiB", formatSize(64<<20))
+}
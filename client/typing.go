@@ -0,0 +1,98 @@
+package client
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// typingRefresh is how often a typing-start is repeated while the user
+	// keeps typing, so that receivers do not expire it.
+	typingRefresh = 3 * time.Second
+	// typingIdle is how long after the last keystroke a typing-stop is sent.
+	typingIdle = 4 * time.Second
+	// typingExpiry is how long a typing-start is shown without a refresh.
+	typingExpiry = 6 * time.Second
+)
+
+// typingNotifier turns edits of the input field into throttled typing-start
+// and typing-stop packets.
+type typingNotifier struct {
+	send func(typing bool) error
+
+	mu       sync.Mutex
+	typing   bool
+	lastSent time.Time
+	idle     *time.Timer
+}
+
+// changed is called on every edit of the input.
+func (t *typingNotifier) changed(text string) {
+	if text == "" || strings.HasPrefix(text, "/") {
+		t.stop()
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.typing || time.Since(t.lastSent) >= typingRefresh {
+		t.typing = true
+		t.lastSent = time.Now()
+		t.send(true)
+	}
+
+	if t.idle == nil {
+		t.idle = time.AfterFunc(typingIdle, t.stop)
+	} else {
+		t.idle.Reset(typingIdle)
+	}
+}
+
+// stop sends a typing-stop if a typing-start was sent before.
+func (t *typingNotifier) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.idle != nil {
+		t.idle.Stop()
+	}
+
+	if t.typing {
+		t.typing = false
+		t.send(false)
+	}
+}
+
+// typingUsers tracks which other users are typing.
+type typingUsers map[string]time.Time
+
+func (tu typingUsers) set(username string, typing bool) {
+	if typing {
+		tu[username] = time.Now().Add(typingExpiry)
+	} else {
+		delete(tu, username)
+	}
+}
+
+// String describes the users currently typing, dropping expired entries.
+func (tu typingUsers) String() string {
+	now := time.Now()
+	maps.DeleteFunc(tu, func(_ string, expires time.Time) bool { return now.After(expires) })
+
+	users := slices.Sorted(maps.Keys(tu))
+	switch len(users) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("%s is typing…", users[0])
+	case 2:
+		return fmt.Sprintf("%s and %s are typing…", users[0], users[1])
+	default:
+		return "Several people are typing…"
+	}
+}
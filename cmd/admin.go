@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/root-man/chat/server"
+	"github.com/spf13/cobra"
+)
+
+var adminSocket string
+
+var adminCmd = &cobra.Command{
+	Use:   "admin [command [args...]]",
+	Short: "Administer a running server through its admin console",
+	Long: `Run a command of the admin console of a server started with --admin-socket,
+or read commands from the terminal when none is given. Type help to list the
+commands, e.g.:
+
+  chat admin --socket chat-admin.sock kick bob spamming`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Errors past this point come from the server, not from the usage
+		cmd.SilenceUsage = true
+
+		conn, err := net.Dial("unix", adminSocket)
+		if err != nil {
+			return fmt.Errorf("failed to connect to the admin console: %w", err)
+		}
+		defer conn.Close()
+
+		responses := json.NewDecoder(conn)
+		run := func(line string) error {
+			command, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+			if err := json.NewEncoder(conn).Encode(&server.AdminRequest{Command: command, Args: rest}); err != nil {
+				return err
+			}
+
+			var resp server.AdminResponse
+			if err := responses.Decode(&resp); err != nil {
+				return err
+			}
+			if resp.Error != "" {
+				return errors.New(resp.Error)
+			}
+			fmt.Println(strings.TrimSuffix(resp.Output, "\n"))
+			return nil
+		}
+
+		if len(args) > 0 {
+			return run(strings.Join(args, " "))
+		}
+
+		lines := bufio.NewScanner(os.Stdin)
+		for fmt.Print("> "); lines.Scan(); fmt.Print("> ") {
+			if strings.TrimSpace(lines.Text()) == "" {
+				continue
+			}
+			if err := run(lines.Text()); errors.Is(err, io.EOF) {
+				return errors.New("the server closed the admin console")
+			} else if err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+			}
+		}
+		fmt.Println()
+		return lines.Err()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(adminCmd)
+	adminCmd.Flags().StringVar(&adminSocket, "socket", "chat-admin.sock", "Unix socket of the admin console")
+}
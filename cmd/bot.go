@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/root-man/chat/bot"
+	"github.com/spf13/cobra"
+)
+
+var botCmd = &cobra.Command{
+	Use:   "bot",
+	Short: "Run chat bots",
+}
+
+var botRunCmd = &cobra.Command{
+	Use:   "run <config>",
+	Short: "Run the example echo and reminder bot",
+	Long: `Run the example bot, which echoes text with !echo, reminds users of things
+with !remind and greets the users greeting it. The JSON configuration file
+holds its name, the host and port of the server, its command prefix and the
+file its reminders are persisted to, e.g.:
+
+  {"name": "remy", "host": "localhost", "port": 4444, "state-file": "remy.json"}`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := bot.LoadConfig(args[0])
+		if err != nil {
+			return err
+		}
+
+		b, err := bot.NewReminderBot(config)
+		if err != nil {
+			return fmt.Errorf("failed to start the bot: %w", err)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return b.Run(ctx)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(botCmd)
+	botCmd.AddCommand(botRunCmd)
+}
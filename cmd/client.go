@@ -4,6 +4,8 @@ Copyright © 2025 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"time"
+
 	"github.com/root-man/chat/client"
 	"github.com/spf13/cobra"
 )
@@ -19,10 +21,15 @@ Cobra is a CLI library for Go that empowers applications.
 This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		client.StartInterface()
+		client.StartInterface(clientConfig)
 	},
 }
 
+var clientConfig client.Config
+
 func init() {
 	rootCmd.AddCommand(clientCmd)
+
+	clientCmd.Flags().StringVar(&clientConfig.DownloadDir, "download-dir", ".", "directory to save received files in")
+	clientCmd.Flags().StringVar(&clientConfig.TimeFormat, "time-format", time.DateTime, `Go time layout to show message times with, e.g. "15:04" or "Jan 2 15:04:05.000"`)
 }
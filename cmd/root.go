@@ -4,11 +4,19 @@ Copyright © 2025 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
-	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"syscall"
 
+	"github.com/root-man/chat/logging"
 	"github.com/root-man/chat/server"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -21,15 +29,44 @@ examples and usage of using your application. For example:
 Cobra is a CLI library for Go that empowers applications.
 This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// The terminal of the client is taken by its interface
+		if cmd == clientCmd && logConfig.File == "" {
+			file, err := clientLogFile()
+			if err != nil {
+				return err
+			}
+			logConfig.File = file
+		}
+
+		return logging.Setup(logConfig)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
-		server, err := server.New(4444)
+		config := serverConfig
+		var load func() (server.Config, error)
+		if configFile != "" {
+			load = configLoader(cmd.Flags())
+			var err error
+			if config, err = load(); err != nil {
+				slog.Error("Failed to load the configuration", "err", err)
+				os.Exit(1)
+			}
+		}
+
+		server, err := server.New(config)
 		if err != nil {
-			fmt.Printf("Failed to start the server: %s", err)
+			slog.Error("Failed to start the server", "err", err)
 			os.Exit(1)
 		}
+		if load != nil {
+			server.SetConfigLoader(load)
+			go reloadOnHangup(server)
+		}
+		go closeOnInterrupt(server)
 
 		if err := server.Run(); err != nil {
-			fmt.Printf("Server exited with error: %s", err)
+			slog.Error("Server exited with error", "err", err)
+			os.Exit(1)
 		}
 	},
 }
@@ -43,6 +80,93 @@ func Execute() {
 	}
 }
 
+var (
+	serverConfig server.Config
+	configFile   string
+	logConfig    logging.Config
+)
+
+// clientLogFile returns the default log file of the client, in the cache
+// directory of the user.
+func clientLogFile() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	dir = filepath.Join(dir, "chat")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "client.log"), nil
+}
+
+// configLoader returns a function reading the configuration file, the flags
+// given on the command line taking precedence over it.
+func configLoader(flags *pflag.FlagSet) func() (server.Config, error) {
+	// Nothing writes to the flags from now on
+	snapshot := serverConfig
+	return func() (server.Config, error) {
+		config := snapshot
+		// Decoding a list reuses the array it replaces
+		config.Moderators = slices.Clone(snapshot.Moderators)
+		if err := server.LoadConfig(configFile, &config); err != nil {
+			return server.Config{}, err
+		}
+
+		fields, flagFields := reflect.ValueOf(&config).Elem(), reflect.ValueOf(snapshot)
+		for i := range fields.NumField() {
+			name, _, _ := strings.Cut(fields.Type().Field(i).Tag.Get("json"), ",")
+			if flags.Changed(name) {
+				fields.Field(i).Set(flagFields.Field(i))
+			}
+		}
+		return config, nil
+	}
+}
+
+// reloadOnHangup reloads the configuration of the server whenever the process
+// receives SIGHUP.
+func reloadOnHangup(s *server.Server) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		if err := s.Reload(); err != nil {
+			slog.Error("Failed to reload the configuration", "err", err)
+		}
+	}
+}
+
+// closeOnInterrupt closes the server when the process is asked to stop, so
+// that the history is saved.
+func closeOnInterrupt(s *server.Server) {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	<-interrupts
+	if err := s.Close(); err != nil {
+		slog.Error("Failed to save the history", "err", err)
+	}
+}
+
 func init() {
-	// rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	rootCmd.PersistentFlags().StringVar(&logConfig.Level, "log-level", "info", `minimum level logged, optionally per component, e.g. "info" or "warn,irc=debug"`)
+	rootCmd.PersistentFlags().StringVar(&logConfig.Format, "log-format", "text", "format of the logs, text or json")
+	rootCmd.PersistentFlags().StringVar(&logConfig.File, "log-file", "", "file to write the logs to, standard error if empty except for the client which logs to its cache directory")
+	rootCmd.Flags().IntVarP(&serverConfig.Port, "port", "p", 4444, "port to listen on")
+	rootCmd.Flags().StringVar(&serverConfig.MOTD, "motd", "", "message of the day sent to users when they connect")
+	rootCmd.Flags().StringVar(&serverConfig.Topic, "topic", "", "topic of the room when the server starts")
+	rootCmd.Flags().IntVar(&serverConfig.RateLimit, "rate-limit", 0, "number of messages a user can send per minute, unlimited if 0")
+	rootCmd.Flags().StringSliceVar(&serverConfig.Moderators, "moderators", nil, "usernames allowed to run privileged commands")
+	rootCmd.Flags().StringVar(&serverConfig.HistoryFile, "history-file", "", "file to persist the message history to, kept in memory if empty")
+	rootCmd.Flags().IntVar(&serverConfig.HistoryLimit, "history-limit", 1000, "number of messages kept in the history")
+	rootCmd.Flags().StringVar(&serverConfig.FileDir, "file-dir", "", "directory to store transferred files in for later download, not stored if empty")
+	rootCmd.Flags().Int64Var(&serverConfig.MaxFileSize, "max-file-size", 64<<20, "size limit of transferred files in bytes")
+	rootCmd.Flags().Int64Var(&serverConfig.FileQuota, "file-quota", 1<<30, "bytes the stored files can take, the oldest being deleted to make room")
+	rootCmd.Flags().Int64Var(&serverConfig.UserFileQuota, "user-file-quota", 256<<20, "bytes the files stored by a single user can take")
+	rootCmd.Flags().IntVar(&serverConfig.WebPort, "web-port", 0, "port of the WebSocket gateway for browsers, disabled if 0")
+	rootCmd.Flags().IntVar(&serverConfig.IRCPort, "irc-port", 0, "port of the IRC gateway, disabled if 0")
+	rootCmd.Flags().IntVar(&serverConfig.APIPort, "api-port", 0, "port of the HTTP API, disabled if 0")
+	rootCmd.Flags().IntVar(&serverConfig.MetricsPort, "metrics-port", 0, "port of the Prometheus metrics endpoint, disabled if 0")
+	rootCmd.Flags().StringVar(&serverConfig.AdminSocket, "admin-socket", "", "Unix socket of the admin console, disabled if empty")
+	rootCmd.Flags().StringVar(&configFile, "config", "", "JSON configuration file, whose keys are the names of the flags, reloaded on SIGHUP")
 }
@@ -0,0 +1,140 @@
+// Package logging configures the structured logs of the server, the client
+// and the bots: their level, per component if need be, their format and
+// where they are written.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Config holds the logging settings, as given on the command line.
+type Config struct {
+	// Level is the minimum level logged, such as "info", optionally followed
+	// by levels for some components, as in "info,irc=debug,webhooks=warn".
+	Level string
+	// Format is "text" or "json".
+	Format string
+	// File is where the logs are written, standard error when it is empty.
+	File string
+}
+
+// settings are what Setup configured. Loggers look them up on every record,
+// so that those created before Setup, such as package level ones, follow it.
+type settings struct {
+	handler slog.Handler
+	level   slog.Level
+	levels  map[string]slog.Level
+}
+
+var current atomic.Pointer[settings]
+
+func init() {
+	current.Store(&settings{handler: slog.NewTextHandler(os.Stderr, nil), level: slog.LevelInfo})
+}
+
+// Setup applies config to every logger, including the default one and so
+// the standard log package. The log file stays open for the life of the
+// process.
+func Setup(config Config) error {
+	s := &settings{levels: make(map[string]slog.Level)}
+	if config.Level == "" {
+		config.Level = "info"
+	}
+
+	for i, part := range strings.Split(config.Level, ",") {
+		component, level, found := strings.Cut(part, "=")
+		if !found {
+			level, component = component, ""
+		}
+		if found != (i > 0) {
+			return fmt.Errorf("invalid log level %q, expected a level such as info followed by component=level pairs", config.Level)
+		}
+
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			return fmt.Errorf("invalid log level %q: %w", level, err)
+		}
+		if found {
+			s.levels[component] = l
+		} else {
+			s.level = l
+		}
+	}
+
+	var w io.Writer = os.Stderr
+	if config.File != "" {
+		file, err := os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return err
+		}
+		w = file
+	}
+
+	// Filtering is left to the loggers, which know their component
+	options := &slog.HandlerOptions{Level: slog.Level(-128)}
+	switch config.Format {
+	case "", "text":
+		s.handler = slog.NewTextHandler(w, options)
+	case "json":
+		s.handler = slog.NewJSONHandler(w, options)
+	default:
+		return fmt.Errorf("invalid log format %q, expected text or json", config.Format)
+	}
+
+	current.Store(s)
+	slog.SetDefault(slog.New(&handler{}))
+	return nil
+}
+
+// For returns the logger of a component, whose records carry its name.
+func For(component string) *slog.Logger {
+	return slog.New(&handler{component: component})
+}
+
+// handler passes the records of a component on to the configured handler.
+type handler struct {
+	component string
+	// with adds the attributes and groups given to the logger.
+	with func(slog.Handler) slog.Handler
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	s := current.Load()
+	if l, ok := s.levels[h.component]; ok {
+		return level >= l
+	}
+	return level >= s.level
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	next := current.Load().handler
+	if h.component != "" {
+		next = next.WithAttrs([]slog.Attr{slog.String("component", h.component)})
+	}
+	if h.with != nil {
+		next = h.with(next)
+	}
+	return next.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.chain(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.chain(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+func (h *handler) chain(with func(slog.Handler) slog.Handler) slog.Handler {
+	previous := h.with
+	if previous == nil {
+		return &handler{component: h.component, with: with}
+	}
+	return &handler{component: h.component, with: func(next slog.Handler) slog.Handler { return with(previous(next)) }}
+}
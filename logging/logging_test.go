@@ -0,0 +1,41 @@
+package logging
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetup(t *testing.T) {
+	// Loggers created before the setup follow it
+	server := For("server")
+	irc := For("irc").With("remote", "127.0.0.1")
+
+	path := t.TempDir() + "/chat.log"
+	require.NoError(t, Setup(Config{Level: "warn,irc=debug", Format: "json", File: path}))
+	server.Info("not logged")
+	server.Warn("logged", "user", "alice")
+	irc.Debug("logged too")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+	assert.Equal(t, "server", record["component"])
+	assert.Equal(t, "alice", record["user"])
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &record))
+	assert.Equal(t, "irc", record["component"])
+	assert.Equal(t, "127.0.0.1", record["remote"])
+	assert.Equal(t, "DEBUG", record["level"])
+
+	assert.Error(t, Setup(Config{Level: "loud"}))
+	assert.Error(t, Setup(Config{Level: "irc=debug"}))
+	assert.Error(t, Setup(Config{Format: "xml"}))
+}
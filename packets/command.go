@@ -0,0 +1,63 @@
+package packets
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Command is a slash command such as "/who" that the client forwards to the
+// server instead of relaying it as a message.
+type Command struct {
+	Name string
+	Args string
+}
+
+func (c *Command) Type() Type {
+	return TypeCommand
+}
+
+func (c *Command) String() string {
+	return fmt.Sprintf("Command: /%s %s", c.Name, c.Args)
+}
+
+func (c *Command) Encode() []byte {
+	nameLength := uint32(len(c.Name))
+	argsLength := uint32(len(c.Args))
+	packet := make([]byte, 8+nameLength+argsLength)
+
+	binary.BigEndian.PutUint32(packet[0:4], nameLength)
+	copy(packet[4:4+nameLength], []byte(c.Name))
+	binary.BigEndian.PutUint32(packet[4+nameLength:8+nameLength], argsLength)
+	copy(packet[8+nameLength:], []byte(c.Args))
+
+	return packet
+}
+
+func (c *Command) Receive(r io.Reader) error {
+	// Read the first 4 bytes to get the length of the command name
+	lengthBytes := make([]byte, 4)
+	if _, err := io.ReadFull(r, lengthBytes); err != nil {
+		return err
+	}
+
+	nameBytes, err := receiveBytes(r, binary.BigEndian.Uint32(lengthBytes))
+	if err != nil {
+		return err
+	}
+
+	// Read the next 4 bytes to get the length of the arguments
+	if _, err := io.ReadFull(r, lengthBytes); err != nil {
+		return err
+	}
+
+	argsBytes, err := receiveBytes(r, binary.BigEndian.Uint32(lengthBytes))
+	if err != nil {
+		return err
+	}
+
+	c.Name = string(nameBytes)
+	c.Args = string(argsBytes)
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package packets
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// CompressionThreshold is the size from which Compress compresses frames.
+	// Below it, the DEFLATE overhead outweighs the savings.
+	CompressionThreshold = 512
+	// maxDecompressedSize bounds the frames carried by a single compressed
+	// frame, so that a small frame cannot inflate into an unbounded buffer.
+	maxDecompressedSize = 16 * MaxFrameSize
+)
+
+var flateWriters = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+// Compress packs one or more consecutive frames into a single compressed
+// frame, whose body is the DEFLATE stream of the frames. Frames smaller than
+// CompressionThreshold, or that would not shrink, are returned as they are.
+// Compressed frames must only be sent to peers that negotiated
+// CapCompression.
+func Compress(frames []byte) []byte {
+	if len(frames) < CompressionThreshold {
+		return frames
+	}
+
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 5))
+
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(&buf)
+	w.Write(frames)
+	w.Close()
+
+	compressed := buf.Bytes()
+	if len(compressed) >= len(frames) || len(compressed)-5 > MaxFrameSize {
+		return frames
+	}
+
+	compressed[0] = byte(TypeCompressed)
+	binary.BigEndian.PutUint32(compressed[1:5], uint32(len(compressed)-5))
+	return compressed
+}
+
+// Reader reads the packets of a stream of frames, unpacking compressed frames.
+type Reader struct {
+	r io.Reader
+	// inflated holds the frames of the last compressed frame not read yet.
+	inflated *bytes.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// ReadPacket reads the next packet. Like ReadFrame, it returns an
+// *UnknownTypeError for a packet of an unknown type, after which the stream
+// can still be read.
+func (r *Reader) ReadPacket() (Packet, error) {
+	if r.inflated != nil && r.inflated.Len() > 0 {
+		return r.decodeInflated()
+	}
+
+	t, body, err := readFrame(r.r)
+	if err != nil {
+		return nil, err
+	}
+
+	if t != TypeCompressed {
+		return decode(t, body)
+	}
+
+	inflated, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(body)), maxDecompressedSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress frame: %w", err)
+	}
+	if len(inflated) > maxDecompressedSize {
+		return nil, fmt.Errorf("compressed frame inflates beyond %d bytes", maxDecompressedSize)
+	}
+
+	r.inflated = bytes.NewReader(inflated)
+	return r.decodeInflated()
+}
+
+func (r *Reader) decodeInflated() (Packet, error) {
+	t, body, err := readFrame(r.inflated)
+	if err != nil {
+		// Compressed frames only hold whole frames, a partial one is an error
+		r.inflated = nil
+		return nil, fmt.Errorf("corrupted compressed frame: %w", err)
+	}
+
+	if t == TypeCompressed {
+		r.inflated = nil
+		return nil, errors.New("nested compressed frame")
+	}
+
+	return decode(t, body)
+}
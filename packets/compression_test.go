@@ -0,0 +1,146 @@
+package packets
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chatLines are typical chat messages, used to build realistic traffic.
+var chatLines = []string{
+	"morning all",
+	"@bob did the deploy go through? https://ci.example.com/builds/4242",
+	"yes, all green 🎉",
+	"lunch at 12:30?",
+	"I'm seeing timeouts from the payments service again, can someone have a look",
+	"looking",
+	"it's the connection pool, we max out at 20 and the batch job grabs all of them",
+	"+1",
+	"let's bump it to 50 and add a separate pool for the batch job",
+	"PR is up: https://git.example.com/payments/pull/1337",
+}
+
+// replay frames n messages the way the server replays the history on login.
+func replay(n int) []byte {
+	var frames []byte
+	for i := range n {
+		msg := &Message{ID: uint64(i + 1), From: []string{"alice", "bob", "carol"}[i%3], Payload: chatLines[i%len(chatLines)], Timestamp: time.Unix(1760000000+int64(i*37), 0)}
+		frames = append(frames, Frame(msg)...)
+	}
+	return frames
+}
+
+// paste frames a message holding a long code paste.
+func paste() []byte {
+	var code strings.Builder
+	for i := range 80 {
+		fmt.Fprintf(&code, "func handler%d(w http.ResponseWriter, r *http.Request) { log.Printf(\"handling %%s\", r.URL) }\n", i)
+	}
+	return Frame(&Message{ID: 1, From: "alice", Payload: code.String(), Timestamp: time.Unix(1760000000, 0)})
+}
+
+func TestCompress(t *testing.T) {
+	short := Frame(&Message{ID: 1, From: "alice", Payload: "hi"})
+	assert.Equal(t, short, Compress(short), "small frames are not compressed")
+
+	frames := append(replay(50), Frame(&Topic{Room: "general", Text: "release day"})...)
+	compressed := Compress(frames)
+	require.Equal(t, byte(TypeCompressed), compressed[0])
+	assert.Less(t, len(compressed), len(frames)/2)
+
+	// Unknown packets inside a compressed frame are skipped like any other
+	unknown := []byte{0x7f, 0, 0, 0, 1, 42}
+	stream := append(append(compressed, Compress(append(unknown, paste()...))...), short...)
+
+	r := NewReader(bytes.NewReader(stream))
+	for i := range 50 {
+		p, err := r.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(i+1), p.(*Message).ID)
+	}
+
+	p, err := r.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, "release day", p.(*Topic).Text)
+
+	_, err = r.ReadPacket()
+	var unknownType *UnknownTypeError
+	assert.True(t, errors.As(err, &unknownType))
+
+	p, err = r.ReadPacket()
+	require.NoError(t, err)
+	assert.Contains(t, p.(*Message).Payload, "handler79")
+
+	p, err = r.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, "hi", p.(*Message).Payload)
+
+	_, err = r.ReadPacket()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReader_CompressionBomb(t *testing.T) {
+	var body bytes.Buffer
+	w, _ := flate.NewWriter(&body, flate.BestCompression)
+	w.Write(make([]byte, maxDecompressedSize+1))
+	w.Close()
+
+	frame := binary.BigEndian.AppendUint32([]byte{byte(TypeCompressed)}, uint32(body.Len()))
+	frame = append(frame, body.Bytes()...)
+
+	_, err := NewReader(bytes.NewReader(frame)).ReadPacket()
+	assert.ErrorContains(t, err, "inflates beyond")
+}
+
+// BenchmarkCompress measures what compression saves on typical traffic and
+// what it costs: a single chat line stays under the threshold, while long
+// pastes and history replays shrink to a fraction of their size.
+func BenchmarkCompress(b *testing.B) {
+	traffic := map[string][]byte{
+		"line":      Frame(&Message{ID: 1, From: "alice", Payload: chatLines[4], Timestamp: time.Unix(1760000000, 0)}),
+		"paste":     paste(),
+		"replay-50": replay(50),
+	}
+
+	for _, name := range []string{"line", "paste", "replay-50"} {
+		frames := traffic[name]
+
+		b.Run(name+"/compress", func(b *testing.B) {
+			var compressed []byte
+			b.SetBytes(int64(len(frames)))
+			for range b.N {
+				compressed = Compress(frames)
+			}
+			b.ReportMetric(float64(len(compressed))/float64(len(frames)), "ratio")
+		})
+
+		// Reading the raw frames is the baseline the decompression adds to
+		for _, mode := range []string{"raw", "compressed"} {
+			stream := frames
+			if mode == "compressed" {
+				stream = Compress(frames)
+			}
+
+			b.Run(name+"/read-"+mode, func(b *testing.B) {
+				b.SetBytes(int64(len(frames)))
+				for range b.N {
+					r := NewReader(bytes.NewReader(stream))
+					for {
+						if _, err := r.ReadPacket(); err != nil {
+							break
+						}
+					}
+				}
+			})
+		}
+	}
+}
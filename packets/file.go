@@ -0,0 +1,219 @@
+package packets
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// FileChunkSize is the largest amount of file data sent in a single
+// FileChunk.
+const FileChunkSize = 32 * 1024
+
+// FileOffer announces a file to a single user, or to the whole room when To is
+// empty. The sender leaves ID empty and the server assigns it before
+// forwarding the offer, echoing it back to the sender as well.
+type FileOffer struct {
+	ID       uint64
+	From     string
+	To       string
+	Name     string
+	Size     uint64
+	Checksum [32]byte
+}
+
+func (fo *FileOffer) Type() Type {
+	return TypeFileOffer
+}
+
+func (fo *FileOffer) String() string {
+	return fmt.Sprintf("FileOffer: #%d %s (%d bytes, sha256 %s) from %s to %q", fo.ID, fo.Name, fo.Size, hex.EncodeToString(fo.Checksum[:]), fo.From, fo.To)
+}
+
+func (fo *FileOffer) Encode() []byte {
+	packet := binary.BigEndian.AppendUint64(nil, fo.ID)
+	packet = appendString(packet, fo.From)
+	packet = appendString(packet, fo.To)
+	packet = appendString(packet, fo.Name)
+	packet = binary.BigEndian.AppendUint64(packet, fo.Size)
+	return append(packet, fo.Checksum[:]...)
+}
+
+func (fo *FileOffer) Receive(r io.Reader) error {
+	// Read the first 8 bytes to get the transfer ID
+	idBytes := make([]byte, 8)
+	if _, err := io.ReadFull(r, idBytes); err != nil {
+		return err
+	}
+	fo.ID = binary.BigEndian.Uint64(idBytes)
+
+	var err error
+	if fo.From, err = receiveString(r); err != nil {
+		return err
+	}
+	if fo.To, err = receiveString(r); err != nil {
+		return err
+	}
+	if fo.Name, err = receiveString(r); err != nil {
+		return err
+	}
+
+	// Read the next 8 bytes to get the size, then the checksum
+	sizeBytes := make([]byte, 8)
+	if _, err := io.ReadFull(r, sizeBytes); err != nil {
+		return err
+	}
+	fo.Size = binary.BigEndian.Uint64(sizeBytes)
+
+	_, err = io.ReadFull(r, fo.Checksum[:])
+	return err
+}
+
+// FileAccept is sent by a user who wants to receive an offered file. The
+// server forwards it to the sender with Username set, and the sender starts
+// uploading on the first acceptance.
+type FileAccept struct {
+	ID       uint64
+	Username string
+}
+
+func (fa *FileAccept) Type() Type {
+	return TypeFileAccept
+}
+
+func (fa *FileAccept) String() string {
+	return fmt.Sprintf("FileAccept: #%d by %s", fa.ID, fa.Username)
+}
+
+func (fa *FileAccept) Encode() []byte {
+	packet := binary.BigEndian.AppendUint64(nil, fa.ID)
+	return appendString(packet, fa.Username)
+}
+
+func (fa *FileAccept) Receive(r io.Reader) error {
+	// Read the first 8 bytes to get the transfer ID
+	idBytes := make([]byte, 8)
+	if _, err := io.ReadFull(r, idBytes); err != nil {
+		return err
+	}
+	fa.ID = binary.BigEndian.Uint64(idBytes)
+
+	var err error
+	fa.Username, err = receiveString(r)
+	return err
+}
+
+// FileChunk carries the part of a file starting at Offset. Chunks are sent in
+// order and hold at most FileChunkSize bytes.
+type FileChunk struct {
+	ID     uint64
+	Offset uint64
+	Data   []byte
+}
+
+func (fc *FileChunk) Type() Type {
+	return TypeFileChunk
+}
+
+func (fc *FileChunk) String() string {
+	return fmt.Sprintf("FileChunk: #%d %d bytes at %d", fc.ID, len(fc.Data), fc.Offset)
+}
+
+func (fc *FileChunk) Encode() []byte {
+	packet := make([]byte, 16, 16+len(fc.Data))
+	binary.BigEndian.PutUint64(packet[0:8], fc.ID)
+	binary.BigEndian.PutUint64(packet[8:16], fc.Offset)
+	return append(packet, fc.Data...)
+}
+
+func (fc *FileChunk) Receive(r io.Reader) error {
+	// Read the first 16 bytes to get the transfer ID and the offset
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+
+	// The data runs until the end of the frame
+	data, err := io.ReadAll(io.LimitReader(r, FileChunkSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > FileChunkSize {
+		return fmt.Errorf("file chunk exceeds %d bytes", FileChunkSize)
+	}
+
+	fc.ID = binary.BigEndian.Uint64(header[0:8])
+	fc.Offset = binary.BigEndian.Uint64(header[8:16])
+	fc.Data = data
+
+	return nil
+}
+
+// FileComplete marks the end of an upload. Receivers check the size and the
+// checksum of what they got against the offer.
+type FileComplete struct {
+	ID uint64
+}
+
+func (fc *FileComplete) Type() Type {
+	return TypeFileComplete
+}
+
+func (fc *FileComplete) String() string {
+	return fmt.Sprintf("FileComplete: #%d", fc.ID)
+}
+
+func (fc *FileComplete) Encode() []byte {
+	return binary.BigEndian.AppendUint64(nil, fc.ID)
+}
+
+func (fc *FileComplete) Receive(r io.Reader) error {
+	idBytes := make([]byte, 8)
+	if _, err := io.ReadFull(r, idBytes); err != nil {
+		return err
+	}
+
+	fc.ID = binary.BigEndian.Uint64(idBytes)
+	return nil
+}
+
+// FileCancel aborts a transfer. Sent by the sender it cancels it for
+// everyone, sent by a receiver it only stops the delivery to them. The server
+// also cancels transfers that fail its checks.
+type FileCancel struct {
+	ID       uint64
+	Username string
+	Reason   string
+}
+
+func (fc *FileCancel) Type() Type {
+	return TypeFileCancel
+}
+
+func (fc *FileCancel) String() string {
+	return fmt.Sprintf("FileCancel: #%d by %s: %s", fc.ID, fc.Username, fc.Reason)
+}
+
+func (fc *FileCancel) Encode() []byte {
+	packet := binary.BigEndian.AppendUint64(nil, fc.ID)
+	packet = appendString(packet, fc.Username)
+	return appendString(packet, fc.Reason)
+}
+
+func (fc *FileCancel) Receive(r io.Reader) error {
+	// Read the first 8 bytes to get the transfer ID
+	idBytes := make([]byte, 8)
+	if _, err := io.ReadFull(r, idBytes); err != nil {
+		return err
+	}
+	fc.ID = binary.BigEndian.Uint64(idBytes)
+
+	var err error
+	if fc.Username, err = receiveString(r); err != nil {
+		return err
+	}
+
+	fc.Reason, err = receiveString(r)
+	return err
+}
@@ -6,19 +6,38 @@ import (
 	"io"
 )
 
+// Capability is a bit set of optional protocol features. The client announces
+// the ones it supports in its Handshake, and the server answers with the ones
+// both sides will use in its HandshakeResponse.
+type Capability uint32
+
+const (
+	// CapCompression allows sending Compressed frames.
+	CapCompression Capability = 1 << iota
+)
+
 type Handshake struct {
-	Username string
+	Username     string
+	Capabilities Capability
+	// Version is the ProtocolVersion spoken by the client.
+	Version uint32
+}
+
+func (h *Handshake) Type() Type {
+	return TypeHandshake
 }
 
 func (h *Handshake) String() string {
-	return fmt.Sprintf("Handshake: user %s", h.Username)
+	return fmt.Sprintf("Handshake: user %s, protocol version %d, capabilities %b", h.Username, h.Version, h.Capabilities)
 }
 
 func (h *Handshake) Encode() []byte {
 	usernameLength := uint32(len(h.Username))
-	packet := make([]byte, 4+usernameLength)
+	packet := make([]byte, 12+usernameLength)
 	binary.BigEndian.PutUint32(packet[0:4], usernameLength)
 	copy(packet[4:], []byte(h.Username))
+	binary.BigEndian.PutUint32(packet[4+usernameLength:8+usernameLength], uint32(h.Capabilities))
+	binary.BigEndian.PutUint32(packet[8+usernameLength:], h.Version)
 	return packet
 }
 
@@ -32,13 +51,34 @@ func (h *Handshake) Receive(r io.Reader) error {
 	usernameLength := binary.BigEndian.Uint32(lengthBytes)
 
 	// Read the username based on the length
-	usernameBytes := make([]byte, usernameLength)
-	if _, err := io.ReadFull(r, usernameBytes); err != nil {
+	usernameBytes, err := receiveBytes(r, usernameLength)
+	if err != nil {
 		return err
 	}
 	username := string(usernameBytes)
 
 	h.Username = username
 
-	return nil
+	capabilities, err := receiveOptional(r, 0)
+	if err != nil {
+		return err
+	}
+	h.Capabilities = Capability(capabilities)
+
+	h.Version, err = receiveOptional(r, 1)
+	return err
+}
+
+// receiveOptional reads one of the 4 bytes fields ending the handshake
+// packets, which were added over time. Peers predating a field do not send
+// it, in which case missing is returned.
+func receiveOptional(r io.Reader, missing uint32) (uint32, error) {
+	fieldBytes := make([]byte, 4)
+	if _, err := io.ReadFull(r, fieldBytes); err == io.EOF {
+		return missing, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint32(fieldBytes), nil
 }
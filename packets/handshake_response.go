@@ -6,12 +6,21 @@ import (
 	"io"
 )
 
+// HandshakeResponse accepts a Handshake. When the versions of the protocol
+// differ, the server only sends its Version and closes the connection.
 type HandshakeResponse struct {
-	OnlineUsers []string
+	OnlineUsers  []string
+	Capabilities Capability
+	// Version is the ProtocolVersion spoken by the server.
+	Version uint32
+}
+
+func (hr *HandshakeResponse) Type() Type {
+	return TypeHandshakeResponse
 }
 
 func (hr *HandshakeResponse) String() string {
-	return fmt.Sprintf("Handshake response: online users %s", hr.OnlineUsers)
+	return fmt.Sprintf("Handshake response: online users %s, protocol version %d, capabilities %b", hr.OnlineUsers, hr.Version, hr.Capabilities)
 }
 
 func (hr *HandshakeResponse) Encode() []byte {
@@ -28,7 +37,8 @@ func (hr *HandshakeResponse) Encode() []byte {
 		packet = append(packet, userPacket...)
 	}
 
-	return packet
+	packet = binary.BigEndian.AppendUint32(packet, uint32(hr.Capabilities))
+	return binary.BigEndian.AppendUint32(packet, hr.Version)
 }
 
 func (hr *HandshakeResponse) Receive(r io.Reader) error {
@@ -39,7 +49,7 @@ func (hr *HandshakeResponse) Receive(r io.Reader) error {
 	}
 
 	numUsers := binary.BigEndian.Uint32(numUsersBytes)
-	onlineUsers := make([]string, numUsers)
+	onlineUsers := make([]string, 0, min(numUsers, 1024))
 
 	for i := uint32(0); i < numUsers; i++ {
 		// Read the next 4 bytes to get the length of the username
@@ -51,14 +61,21 @@ func (hr *HandshakeResponse) Receive(r io.Reader) error {
 		usernameLength := binary.BigEndian.Uint32(lengthBytes)
 
 		// Read the username based on the length
-		usernameBytes := make([]byte, usernameLength)
-		if _, err := io.ReadFull(r, usernameBytes); err != nil {
+		usernameBytes, err := receiveBytes(r, usernameLength)
+		if err != nil {
 			return err
 		}
-		onlineUsers[i] = string(usernameBytes)
+		onlineUsers = append(onlineUsers, string(usernameBytes))
 	}
 
 	hr.OnlineUsers = onlineUsers
 
-	return nil
+	capabilities, err := receiveOptional(r, 0)
+	if err != nil {
+		return err
+	}
+	hr.Capabilities = Capability(capabilities)
+
+	hr.Version, err = receiveOptional(r, 1)
+	return err
 }
@@ -0,0 +1,82 @@
+package packets
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// typeNames name the packet types in their JSON form.
+var typeNames = map[Type]string{
+	TypeHandshake:         "handshake",
+	TypeHandshakeResponse: "handshakeResponse",
+	TypeMessage:           "message",
+	TypePresence:          "presence",
+	TypeCommand:           "command",
+	TypeRename:            "rename",
+	TypeMotd:              "motd",
+	TypeTopic:             "topic",
+	TypeTyping:            "typing",
+	TypeReadReceipt:       "readReceipt",
+	TypeMessageEdit:       "messageEdit",
+	TypeMessageDelete:     "messageDelete",
+	TypeReaction:          "reaction",
+	TypeMentionList:       "mentionList",
+	TypeSearchRequest:     "searchRequest",
+	TypeSearchResults:     "searchResults",
+	TypeFileOffer:         "fileOffer",
+	TypeFileAccept:        "fileAccept",
+	TypeFileChunk:         "fileChunk",
+	TypeFileComplete:      "fileComplete",
+	TypeFileCancel:        "fileCancel",
+}
+
+// envelope is the JSON form of a packet, such as
+// {"type": "message", "packet": {"From": "alice", "Payload": "hi", ...}}.
+type envelope struct {
+	Type   string          `json:"type"`
+	Packet json.RawMessage `json:"packet"`
+}
+
+// MarshalJSON encodes p as JSON, for clients that cannot speak the binary
+// protocol such as browsers.
+func MarshalJSON(p Packet) ([]byte, error) {
+	name, ok := typeNames[p.Type()]
+	if !ok {
+		return nil, fmt.Errorf("packet type %d has no JSON form", p.Type())
+	}
+
+	fields, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(envelope{Type: name, Packet: fields})
+}
+
+// UnmarshalJSON decodes a packet encoded by MarshalJSON. Like ReadFrame, it
+// returns an *UnknownTypeError for a type it does not know.
+func UnmarshalJSON(data []byte) (Packet, error) {
+	var e envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+
+	for t, name := range typeNames {
+		if name != e.Type {
+			continue
+		}
+
+		p, err := New(t)
+		if err != nil {
+			return nil, err
+		}
+		if len(e.Packet) > 0 {
+			if err := json.Unmarshal(e.Packet, p); err != nil {
+				return nil, fmt.Errorf("invalid %s packet: %w", name, err)
+			}
+		}
+		return p, nil
+	}
+
+	return nil, &UnknownTypeError{Name: e.Type}
+}
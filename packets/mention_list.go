@@ -0,0 +1,55 @@
+package packets
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Mentions returns the usernames mentioned as "@username" in a message
+// payload, without duplicates.
+func Mentions(payload string) []string {
+	var mentions []string
+	for _, word := range strings.Fields(payload) {
+		if !strings.HasPrefix(word, "@") {
+			continue
+		}
+
+		name := strings.TrimRightFunc(word[1:], unicode.IsPunct)
+		if name != "" && !slices.Contains(mentions, name) {
+			mentions = append(mentions, name)
+		}
+	}
+	return mentions
+}
+
+// MentionList is the server's answer to /mentions: the recent messages that
+// mentioned the user, oldest first.
+type MentionList struct {
+	Messages []Message
+}
+
+func (ml *MentionList) Type() Type {
+	return TypeMentionList
+}
+
+func (ml *MentionList) String() string {
+	return fmt.Sprintf("MentionList: %d messages", len(ml.Messages))
+}
+
+func (ml *MentionList) Encode() []byte {
+	return encodeMessages(nil, ml.Messages)
+}
+
+func (ml *MentionList) Receive(r io.Reader) error {
+	messages, err := receiveMessages(r)
+	if err != nil {
+		return err
+	}
+
+	ml.Messages = messages
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package packets
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -8,33 +9,76 @@ import (
 )
 
 type Message struct {
-	From      string
-	Payload   string
+	// ID is assigned by the server when it relays the message. Messages
+	// generated by the server itself have no ID.
+	ID uint64
+	// ParentID is the ID of the message this one replies to in a thread, or 0
+	// for a message of the main timeline.
+	ParentID uint64
+	From     string
+	Payload  string
+	// Timestamp is when the sender's clock says the message was sent.
 	Timestamp time.Time
+	// ServerTime is when the server received the message, set by the server
+	// when it relays it. It is the time to trust, client clocks can be off.
+	ServerTime time.Time
+	// Edited is set once the payload was changed by a MessageEdit.
+	Edited bool
+}
+
+func (m *Message) Type() Type {
+	return TypeMessage
 }
 
 func (m *Message) String() string {
-	return fmt.Sprintf("Message: From %s at %s", m.From, m.Timestamp)
+	return fmt.Sprintf("Message: #%d From %s at %s", m.ID, m.From, m.Time())
+}
+
+// Time returns when the message was sent according to the server, or to the
+// sender if the server did not say.
+func (m *Message) Time() time.Time {
+	if !m.ServerTime.IsZero() {
+		return m.ServerTime
+	}
+	return m.Timestamp
 }
 
 func (m *Message) Encode() []byte {
 	fromLength := uint32(len(m.From))
 	messageLength := uint32(len(m.Payload))
-	timestamp := m.Timestamp.Unix()
 
-	packet := make([]byte, 16+fromLength+messageLength)
+	packet := make([]byte, 41+fromLength+messageLength)
 
-	binary.BigEndian.PutUint32(packet[0:4], fromLength)
-	copy(packet[4:4+fromLength], []byte(m.From))
-	binary.BigEndian.PutUint32(packet[4+fromLength:8+fromLength], messageLength)
-	copy(packet[8+fromLength:8+fromLength+messageLength], []byte(m.Payload))
-	binary.BigEndian.PutUint64(packet[8+fromLength+messageLength:], uint64(timestamp))
+	binary.BigEndian.PutUint64(packet[0:8], m.ID)
+	binary.BigEndian.PutUint64(packet[8:16], m.ParentID)
+	binary.BigEndian.PutUint32(packet[16:20], fromLength)
+	copy(packet[20:20+fromLength], []byte(m.From))
+	binary.BigEndian.PutUint32(packet[20+fromLength:24+fromLength], messageLength)
+	copy(packet[24+fromLength:24+fromLength+messageLength], []byte(m.Payload))
+	binary.BigEndian.PutUint64(packet[24+fromLength+messageLength:32+fromLength+messageLength], unixNano(m.Timestamp))
+	binary.BigEndian.PutUint64(packet[32+fromLength+messageLength:40+fromLength+messageLength], unixNano(m.ServerTime))
+
+	if m.Edited {
+		packet[len(packet)-1] = 1
+	}
 
 	return packet
 }
 
 func (m *Message) Receive(r io.Reader) error {
-	// Read the first 4 bytes to get the length of the username
+	// Read the first 8 bytes to get the message ID
+	idBytes := make([]byte, 8)
+	if _, err := io.ReadFull(r, idBytes); err != nil {
+		return err
+	}
+
+	// Read the next 8 bytes to get the parent message ID
+	parentIDBytes := make([]byte, 8)
+	if _, err := io.ReadFull(r, parentIDBytes); err != nil {
+		return err
+	}
+
+	// Read the next 4 bytes to get the length of the username
 	lengthBytes := make([]byte, 4)
 	if _, err := io.ReadFull(r, lengthBytes); err != nil {
 		return err
@@ -43,8 +87,8 @@ func (m *Message) Receive(r io.Reader) error {
 	usernameLength := binary.BigEndian.Uint32(lengthBytes)
 
 	// Read the username based on the length
-	usernameBytes := make([]byte, usernameLength)
-	if _, err := io.ReadFull(r, usernameBytes); err != nil {
+	usernameBytes, err := receiveBytes(r, usernameLength)
+	if err != nil {
 		return err
 	}
 	username := string(usernameBytes)
@@ -56,21 +100,77 @@ func (m *Message) Receive(r io.Reader) error {
 	messageLength := binary.BigEndian.Uint32(lengthBytes)
 
 	// Read the message based on the length
-	messageBytes := make([]byte, messageLength)
-	if _, err := io.ReadFull(r, messageBytes); err != nil {
+	messageBytes, err := receiveBytes(r, messageLength)
+	if err != nil {
 		return err
 	}
 
-	// Read the next 8 bytes to get the timestamp
-	timestampBytes := make([]byte, 8)
+	// Read the next 16 bytes to get the timestamps of the sender and of the
+	// server
+	timestampBytes := make([]byte, 16)
 	if _, err := io.ReadFull(r, timestampBytes); err != nil {
 		return err
 	}
-	timestamp := time.Unix(int64(binary.BigEndian.Uint64(timestampBytes)), 0)
 
+	// Read the edited flag
+	editedByte := make([]byte, 1)
+	if _, err := io.ReadFull(r, editedByte); err != nil {
+		return err
+	}
+
+	m.ID = binary.BigEndian.Uint64(idBytes)
+	m.ParentID = binary.BigEndian.Uint64(parentIDBytes)
 	m.From = username
 	m.Payload = string(messageBytes)
-	m.Timestamp = timestamp
+	m.Timestamp = fromUnixNano(binary.BigEndian.Uint64(timestampBytes[0:8]))
+	m.ServerTime = fromUnixNano(binary.BigEndian.Uint64(timestampBytes[8:16]))
+	m.Edited = editedByte[0] == 1
 
 	return nil
 }
+
+// encodeMessages appends a list of messages to packet, each prefixed by the
+// length of its encoding.
+func encodeMessages(packet []byte, messages []Message) []byte {
+	packet = binary.BigEndian.AppendUint32(packet, uint32(len(messages)))
+
+	for _, m := range messages {
+		encoded := m.Encode()
+		packet = binary.BigEndian.AppendUint32(packet, uint32(len(encoded)))
+		packet = append(packet, encoded...)
+	}
+
+	return packet
+}
+
+// receiveMessages reads a list of messages written by encodeMessages.
+func receiveMessages(r io.Reader) ([]Message, error) {
+	// Read the first 4 bytes to get the number of messages
+	lengthBytes := make([]byte, 4)
+	if _, err := io.ReadFull(r, lengthBytes); err != nil {
+		return nil, err
+	}
+
+	numMessages := binary.BigEndian.Uint32(lengthBytes)
+	messages := make([]Message, 0, min(numMessages, 1024))
+
+	for i := uint32(0); i < numMessages; i++ {
+		// Read the next 4 bytes to get the length of the encoded message
+		if _, err := io.ReadFull(r, lengthBytes); err != nil {
+			return nil, err
+		}
+
+		messageBytes, err := receiveBytes(r, binary.BigEndian.Uint32(lengthBytes))
+		if err != nil {
+			return nil, err
+		}
+
+		var m Message
+		if err := m.Receive(bytes.NewReader(messageBytes)); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+
+	return messages, nil
+}
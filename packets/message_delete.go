@@ -0,0 +1,57 @@
+package packets
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MessageDelete removes the message with the given ID. Clients send it with an
+// empty DeletedBy, the server fills it in when relaying.
+type MessageDelete struct {
+	ID        uint64
+	DeletedBy string
+}
+
+func (d *MessageDelete) Type() Type {
+	return TypeMessageDelete
+}
+
+func (d *MessageDelete) String() string {
+	return fmt.Sprintf("MessageDelete: #%d deleted by %s", d.ID, d.DeletedBy)
+}
+
+func (d *MessageDelete) Encode() []byte {
+	deletedByLength := uint32(len(d.DeletedBy))
+	packet := make([]byte, 12+deletedByLength)
+
+	binary.BigEndian.PutUint64(packet[0:8], d.ID)
+	binary.BigEndian.PutUint32(packet[8:12], deletedByLength)
+	copy(packet[12:], []byte(d.DeletedBy))
+
+	return packet
+}
+
+func (d *MessageDelete) Receive(r io.Reader) error {
+	// Read the first 8 bytes to get the message ID
+	idBytes := make([]byte, 8)
+	if _, err := io.ReadFull(r, idBytes); err != nil {
+		return err
+	}
+
+	// Read the next 4 bytes to get the length of the username
+	lengthBytes := make([]byte, 4)
+	if _, err := io.ReadFull(r, lengthBytes); err != nil {
+		return err
+	}
+
+	deletedByBytes, err := receiveBytes(r, binary.BigEndian.Uint32(lengthBytes))
+	if err != nil {
+		return err
+	}
+
+	d.ID = binary.BigEndian.Uint64(idBytes)
+	d.DeletedBy = string(deletedByBytes)
+
+	return nil
+}
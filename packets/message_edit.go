@@ -0,0 +1,72 @@
+package packets
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MessageEdit replaces the payload of the message with the given ID. Clients
+// send it with an empty EditedBy, the server fills it in when relaying.
+type MessageEdit struct {
+	ID       uint64
+	EditedBy string
+	Payload  string
+}
+
+func (e *MessageEdit) Type() Type {
+	return TypeMessageEdit
+}
+
+func (e *MessageEdit) String() string {
+	return fmt.Sprintf("MessageEdit: #%d edited by %s", e.ID, e.EditedBy)
+}
+
+func (e *MessageEdit) Encode() []byte {
+	editedByLength := uint32(len(e.EditedBy))
+	payloadLength := uint32(len(e.Payload))
+	packet := make([]byte, 16+editedByLength+payloadLength)
+
+	binary.BigEndian.PutUint64(packet[0:8], e.ID)
+	binary.BigEndian.PutUint32(packet[8:12], editedByLength)
+	copy(packet[12:12+editedByLength], []byte(e.EditedBy))
+	binary.BigEndian.PutUint32(packet[12+editedByLength:16+editedByLength], payloadLength)
+	copy(packet[16+editedByLength:], []byte(e.Payload))
+
+	return packet
+}
+
+func (e *MessageEdit) Receive(r io.Reader) error {
+	// Read the first 8 bytes to get the message ID
+	idBytes := make([]byte, 8)
+	if _, err := io.ReadFull(r, idBytes); err != nil {
+		return err
+	}
+
+	// Read the next 4 bytes to get the length of the editor's username
+	lengthBytes := make([]byte, 4)
+	if _, err := io.ReadFull(r, lengthBytes); err != nil {
+		return err
+	}
+
+	editedByBytes, err := receiveBytes(r, binary.BigEndian.Uint32(lengthBytes))
+	if err != nil {
+		return err
+	}
+
+	// Read the next 4 bytes to get the length of the new payload
+	if _, err := io.ReadFull(r, lengthBytes); err != nil {
+		return err
+	}
+
+	payloadBytes, err := receiveBytes(r, binary.BigEndian.Uint32(lengthBytes))
+	if err != nil {
+		return err
+	}
+
+	e.ID = binary.BigEndian.Uint64(idBytes)
+	e.EditedBy = string(editedByBytes)
+	e.Payload = string(payloadBytes)
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package packets
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Motd is the server's message of the day, sent right after the handshake
+// response.
+type Motd struct {
+	Text string
+}
+
+func (m *Motd) Type() Type {
+	return TypeMotd
+}
+
+func (m *Motd) String() string {
+	return fmt.Sprintf("Motd: %d bytes", len(m.Text))
+}
+
+func (m *Motd) Encode() []byte {
+	textLength := uint32(len(m.Text))
+	packet := make([]byte, 4+textLength)
+	binary.BigEndian.PutUint32(packet[0:4], textLength)
+	copy(packet[4:], []byte(m.Text))
+	return packet
+}
+
+func (m *Motd) Receive(r io.Reader) error {
+	// Read the first 4 bytes to get the length of the text
+	lengthBytes := make([]byte, 4)
+	if _, err := io.ReadFull(r, lengthBytes); err != nil {
+		return err
+	}
+
+	textBytes, err := receiveBytes(r, binary.BigEndian.Uint32(lengthBytes))
+	if err != nil {
+		return err
+	}
+
+	m.Text = string(textBytes)
+
+	return nil
+}
@@ -1,11 +1,255 @@
 package packets
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"time"
 )
 
+// Type identifies the kind of packet carried by a frame.
+type Type byte
+
+const (
+	TypeHandshake Type = iota + 1
+	TypeHandshakeResponse
+	TypeMessage
+	TypePresence
+	TypeCommand
+	TypeRename
+	TypeMotd
+	TypeTopic
+	TypeTyping
+	TypeReadReceipt
+	TypeMessageEdit
+	TypeMessageDelete
+	TypeReaction
+	TypeMentionList
+	TypeSearchRequest
+	TypeSearchResults
+	TypeFileOffer
+	TypeFileAccept
+	TypeFileChunk
+	TypeFileComplete
+	TypeFileCancel
+	TypeCompressed
+)
+
+// String returns the name of the type, as used in JSON.
+func (t Type) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("type %d", byte(t))
+}
+
+// ProtocolVersion is the version of the encoding of the packets, exchanged
+// in the handshake. Peers speaking different versions cannot talk together.
+//
+// Version 2 made timestamps nanosecond precise and added Message.ServerTime.
+const ProtocolVersion = 2
+
+// MaxFrameSize is the largest frame body ReadFrame accepts.
+const MaxFrameSize = 1 << 20
+
 type Packet interface {
+	Type() Type
 	Encode() []byte
 	Receive(io.Reader) error
 	String() string
 }
+
+// New returns an empty packet of the given type, ready to Receive into.
+func New(t Type) (Packet, error) {
+	switch t {
+	case TypeHandshake:
+		return &Handshake{}, nil
+	case TypeHandshakeResponse:
+		return &HandshakeResponse{}, nil
+	case TypeMessage:
+		return &Message{}, nil
+	case TypePresence:
+		return &Presence{}, nil
+	case TypeCommand:
+		return &Command{}, nil
+	case TypeRename:
+		return &Rename{}, nil
+	case TypeMotd:
+		return &Motd{}, nil
+	case TypeTopic:
+		return &Topic{}, nil
+	case TypeTyping:
+		return &Typing{}, nil
+	case TypeReadReceipt:
+		return &ReadReceipt{}, nil
+	case TypeMessageEdit:
+		return &MessageEdit{}, nil
+	case TypeMessageDelete:
+		return &MessageDelete{}, nil
+	case TypeReaction:
+		return &Reaction{}, nil
+	case TypeMentionList:
+		return &MentionList{}, nil
+	case TypeSearchRequest:
+		return &SearchRequest{}, nil
+	case TypeSearchResults:
+		return &SearchResults{}, nil
+	case TypeFileOffer:
+		return &FileOffer{}, nil
+	case TypeFileAccept:
+		return &FileAccept{}, nil
+	case TypeFileChunk:
+		return &FileChunk{}, nil
+	case TypeFileComplete:
+		return &FileComplete{}, nil
+	case TypeFileCancel:
+		return &FileCancel{}, nil
+	}
+
+	return nil, &UnknownTypeError{Type: t}
+}
+
+// UnknownTypeError is returned by ReadFrame for a frame of an unknown type. The
+// frame body has already been consumed, so the stream can still be read.
+type UnknownTypeError struct {
+	Type Type
+	// Name is the type of a JSON packet, see UnmarshalJSON.
+	Name string
+}
+
+func (e *UnknownTypeError) Error() string {
+	if e.Name != "" {
+		return fmt.Sprintf("unknown packet type %q", e.Name)
+	}
+	return fmt.Sprintf("unknown packet type %d", e.Type)
+}
+
+// Frame encodes p prefixed with its type and the length of its body.
+func Frame(p Packet) []byte {
+	body := p.Encode()
+	frame := make([]byte, 5+len(body))
+	frame[0] = byte(p.Type())
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(body)))
+	copy(frame[5:], body)
+	return frame
+}
+
+// ReadFrame reads a single frame written by Frame and decodes its packet.
+// Compressed frames are only understood by a Reader.
+func ReadFrame(r io.Reader) (Packet, error) {
+	t, body, err := readFrame(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if t == TypeCompressed {
+		return nil, errors.New("compressed frame read outside of a Reader")
+	}
+
+	return decode(t, body)
+}
+
+// readFrame reads the type and the body of a frame.
+func readFrame(r io.Reader) (Type, []byte, error) {
+	// Read the 1 byte type and the 4 bytes body length
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[1:5])
+	if length > MaxFrameSize {
+		return 0, nil, fmt.Errorf("frame of %d bytes exceeds the maximum of %d", length, MaxFrameSize)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+
+	return Type(header[0]), body, nil
+}
+
+// decode decodes the body of a frame of type t.
+func decode(t Type, body []byte) (Packet, error) {
+	p, err := New(t)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.Receive(bytes.NewReader(body)); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// appendString appends s to packet prefixed by its 4 bytes length.
+func appendString(packet []byte, s string) []byte {
+	packet = binary.BigEndian.AppendUint32(packet, uint32(len(s)))
+	return append(packet, s...)
+}
+
+// receiveString reads a string written by appendString.
+func receiveString(r io.Reader) (string, error) {
+	lengthBytes := make([]byte, 4)
+	if _, err := io.ReadFull(r, lengthBytes); err != nil {
+		return "", err
+	}
+
+	stringBytes, err := receiveBytes(r, binary.BigEndian.Uint32(lengthBytes))
+	if err != nil {
+		return "", err
+	}
+
+	return string(stringBytes), nil
+}
+
+// receiveBytes reads a field of n bytes, n coming from the frame itself. It
+// is checked against what is left of the frame before allocating, so that a
+// small frame cannot claim a huge field.
+func receiveBytes(r io.Reader, n uint32) ([]byte, error) {
+	if rest, ok := r.(interface{ Len() int }); (ok && int64(n) > int64(rest.Len())) || n > MaxFrameSize {
+		return nil, fmt.Errorf("field of %d bytes exceeds its frame", n)
+	}
+
+	field := make([]byte, n)
+	if _, err := io.ReadFull(r, field); err != nil {
+		return nil, err
+	}
+	return field, nil
+}
+
+// appendTime appends t to packet as 8 bytes of Unix nanoseconds, 0 standing
+// for the zero time.
+func appendTime(packet []byte, t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(packet, unixNano(t))
+}
+
+// receiveTime reads a time written by appendTime.
+func receiveTime(r io.Reader) (time.Time, error) {
+	timestampBytes := make([]byte, 8)
+	if _, err := io.ReadFull(r, timestampBytes); err != nil {
+		return time.Time{}, err
+	}
+
+	return fromUnixNano(binary.BigEndian.Uint64(timestampBytes)), nil
+}
+
+// unixNano returns t in Unix nanoseconds, or 0 for the zero time.
+func unixNano(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+// fromUnixNano is the reverse of unixNano.
+func fromUnixNano(timestamp uint64) time.Time {
+	if timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(timestamp))
+}
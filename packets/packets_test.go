@@ -2,6 +2,8 @@ package packets
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"errors"
 	"testing"
 	"time"
 
@@ -52,12 +54,16 @@ func TestPresence_Receive(t *testing.T) {
 
 func TestHandshake_Encode(t *testing.T) {
 	h := Handshake{
-		Username: "testuser",
+		Username:     "testuser",
+		Capabilities: CapCompression,
+		Version:      2,
 	}
 
 	expected := []byte{
 		0, 0, 0, 8, // Length of the username (8 bytes)
 		't', 'e', 's', 't', 'u', 's', 'e', 'r', // Username
+		0, 0, 0, 1, // Capabilities
+		0, 0, 0, 2, // Protocol version
 	}
 
 	encoded := h.Encode()
@@ -68,6 +74,7 @@ func TestHandshake_Encode(t *testing.T) {
 }
 
 func TestHandshake_Receive(t *testing.T) {
+	// Clients predating capabilities end the handshake after the username
 	data := []byte{
 		0, 0, 0, 8, // Length of the username (8 bytes)
 		't', 'e', 's', 't', 'u', 's', 'e', 'r', // Username
@@ -81,6 +88,7 @@ func TestHandshake_Receive(t *testing.T) {
 
 	expected := Handshake{
 		Username: "testuser",
+		Version:  1,
 	}
 
 	if h != expected {
@@ -99,6 +107,8 @@ func TestHandshakeResponse_Encode(t *testing.T) {
 		'u', 's', 'e', 'r', '1', // First username
 		0, 0, 0, 5, // Length of the second username (5 bytes)
 		'u', 's', 'e', 'r', '2', // Second username
+		0, 0, 0, 0, // Capabilities
+		0, 0, 0, 0, // Protocol version
 	}
 
 	encoded := hr.Encode()
@@ -125,6 +135,7 @@ func TestHandshakeResponse_Receive(t *testing.T) {
 
 	expected := HandshakeResponse{
 		OnlineUsers: []string{"user1", "user2"},
+		Version:     1,
 	}
 
 	if !assert.Equal(t, hr, expected) {
@@ -134,17 +145,23 @@ func TestHandshakeResponse_Receive(t *testing.T) {
 
 func TestMessage_Encode(t *testing.T) {
 	m := Message{
-		From:      "testuser",
-		Payload:   "Hello, world!",
-		Timestamp: time.Unix(256, 0), // Example timestamp
+		ID:         2,
+		From:       "testuser",
+		Payload:    "Hello, world!",
+		Timestamp:  time.Unix(0, 256), // Example timestamp
+		ServerTime: time.Unix(0, 257),
 	}
 
 	expected := []byte{
+		0, 0, 0, 0, 0, 0, 0, 2, // Message ID (2)
+		0, 0, 0, 0, 0, 0, 0, 0, // Parent message ID (none)
 		0, 0, 0, 8, // Length of the username (8 bytes)
 		't', 'e', 's', 't', 'u', 's', 'e', 'r', // Username
 		0, 0, 0, 13, // Length of the message (13 bytes)
 		'H', 'e', 'l', 'l', 'o', ',', ' ', 'w', 'o', 'r', 'l', 'd', '!', // Message
-		0, 0, 0, 0, 0, 0, 1, 0, // Timestamp (256)
+		0, 0, 0, 0, 0, 0, 1, 0, // Timestamp (256ns)
+		0, 0, 0, 0, 0, 0, 1, 1, // Server time (257ns)
+		0, // Edited (false)
 	}
 
 	encoded := m.Encode()
@@ -156,11 +173,15 @@ func TestMessage_Encode(t *testing.T) {
 
 func TestMessage_Receive(t *testing.T) {
 	data := []byte{
+		0, 0, 0, 0, 0, 0, 0, 3, // Message ID (3)
+		0, 0, 0, 0, 0, 0, 0, 1, // Parent message ID (1)
 		0, 0, 0, 8, // Length of the username (8 bytes)
 		't', 'e', 's', 't', 'u', 's', 'e', 'r', // Username
 		0, 0, 0, 13, // Length of the message (13 bytes)
 		'H', 'e', 'l', 'l', 'o', ',', ' ', 'w', 'o', 'r', 'l', 'd', '!', // Message
-		0, 0, 0, 0, 0, 0, 1, 1, // Timestamp (257)
+		0, 0, 0, 0, 0, 0, 1, 1, // Timestamp (257ns)
+		0, 0, 0, 0, 0, 0, 0, 0, // Server time (unset)
+		1, // Edited (true)
 	}
 
 	r := bytes.NewReader(data)
@@ -170,12 +191,379 @@ func TestMessage_Receive(t *testing.T) {
 	}
 
 	expected := Message{
+		ID:        3,
+		ParentID:  1,
 		From:      "testuser",
 		Payload:   "Hello, world!",
-		Timestamp: time.Unix(257, 0), // Example timestamp
+		Timestamp: time.Unix(0, 257), // Example timestamp
+		Edited:    true,
 	}
 
 	if m != expected {
 		t.Errorf("Receive() = %v, want %v", m, expected)
 	}
 }
+
+func TestCommand_Encode(t *testing.T) {
+	c := Command{
+		Name: "me",
+		Args: "waves",
+	}
+
+	expected := []byte{
+		0, 0, 0, 2, // Length of the command name (2 bytes)
+		'm', 'e', // Command name
+		0, 0, 0, 5, // Length of the arguments (5 bytes)
+		'w', 'a', 'v', 'e', 's', // Arguments
+	}
+
+	encoded := c.Encode()
+
+	if !bytes.Equal(encoded, expected) {
+		t.Errorf("Encode() = %v, want %v", encoded, expected)
+	}
+}
+
+func TestCommand_Receive(t *testing.T) {
+	data := []byte{
+		0, 0, 0, 3, // Length of the command name (3 bytes)
+		'w', 'h', 'o', // Command name
+		0, 0, 0, 0, // Length of the arguments (0 bytes)
+	}
+
+	r := bytes.NewReader(data)
+	var c Command
+	if err := c.Receive(r); err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+
+	expected := Command{
+		Name: "who",
+	}
+
+	if c != expected {
+		t.Errorf("Receive() = %v, want %v", c, expected)
+	}
+}
+
+func TestFrame(t *testing.T) {
+	p := &Presence{Username: "testuser", Status: true}
+
+	expected := []byte{
+		byte(TypePresence), // Packet type
+		0, 0, 0, 13,        // Length of the body (13 bytes)
+		0, 0, 0, 8, // Length of the username (8 bytes)
+		't', 'e', 's', 't', 'u', 's', 'e', 'r', // Username
+		1, // Status (true)
+	}
+
+	framed := Frame(p)
+
+	if !bytes.Equal(framed, expected) {
+		t.Errorf("Frame() = %v, want %v", framed, expected)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(Frame(&Command{Name: "me", Args: "waves"}))
+	buf.Write([]byte{255, 0, 0, 0, 1, 0}) // Unknown packet type
+	buf.Write(Frame(&Handshake{Username: "testuser"}))
+
+	p, err := ReadFrame(&buf)
+	if err != nil {
+		t.Fatalf("ReadFrame() error = %v", err)
+	}
+	assert.Equal(t, &Command{Name: "me", Args: "waves"}, p)
+
+	_, err = ReadFrame(&buf)
+	var unknown *UnknownTypeError
+	if !errors.As(err, &unknown) {
+		t.Fatalf("ReadFrame() error = %v, want UnknownTypeError", err)
+	}
+
+	p, err = ReadFrame(&buf)
+	if err != nil {
+		t.Fatalf("ReadFrame() error = %v", err)
+	}
+	assert.Equal(t, &Handshake{Username: "testuser"}, p)
+}
+
+func TestRename_EncodeReceive(t *testing.T) {
+	rn := Rename{From: "alice", To: "bob"}
+
+	expected := []byte{
+		0, 0, 0, 5, // Length of the old username (5 bytes)
+		'a', 'l', 'i', 'c', 'e', // Old username
+		0, 0, 0, 3, // Length of the new username (3 bytes)
+		'b', 'o', 'b', // New username
+	}
+
+	encoded := rn.Encode()
+	if !bytes.Equal(encoded, expected) {
+		t.Errorf("Encode() = %v, want %v", encoded, expected)
+	}
+
+	var received Rename
+	if err := received.Receive(bytes.NewReader(encoded)); err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+
+	if received != rn {
+		t.Errorf("Receive() = %v, want %v", received, rn)
+	}
+}
+
+func TestTopic_EncodeReceive(t *testing.T) {
+	topic := Topic{Room: "general", Text: "Release day", SetBy: "alice", SetAt: time.Unix(0, 256)}
+
+	expected := []byte{
+		0, 0, 0, 7, // Length of the room (7 bytes)
+		'g', 'e', 'n', 'e', 'r', 'a', 'l', // Room
+		0, 0, 0, 11, // Length of the topic (11 bytes)
+		'R', 'e', 'l', 'e', 'a', 's', 'e', ' ', 'd', 'a', 'y', // Topic
+		0, 0, 0, 5, // Length of the author (5 bytes)
+		'a', 'l', 'i', 'c', 'e', // Author
+		0, 0, 0, 0, 0, 0, 1, 0, // Timestamp (256ns)
+	}
+
+	encoded := topic.Encode()
+	if !bytes.Equal(encoded, expected) {
+		t.Errorf("Encode() = %v, want %v", encoded, expected)
+	}
+
+	var received Topic
+	if err := received.Receive(bytes.NewReader(encoded)); err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+
+	if received != topic {
+		t.Errorf("Receive() = %v, want %v", received, topic)
+	}
+}
+
+func TestTyping_EncodeReceive(t *testing.T) {
+	typing := Typing{Username: "alice", Typing: true}
+
+	expected := []byte{
+		0, 0, 0, 5, // Length of the username (5 bytes)
+		'a', 'l', 'i', 'c', 'e', // Username
+		1, // Typing (true)
+	}
+
+	encoded := typing.Encode()
+	if !bytes.Equal(encoded, expected) {
+		t.Errorf("Encode() = %v, want %v", encoded, expected)
+	}
+
+	var received Typing
+	if err := received.Receive(bytes.NewReader(encoded)); err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+
+	if received != typing {
+		t.Errorf("Receive() = %v, want %v", received, typing)
+	}
+}
+
+func TestMessageEdit_EncodeReceive(t *testing.T) {
+	edit := MessageEdit{ID: 7, EditedBy: "bob", Payload: "fixed"}
+
+	expected := []byte{
+		0, 0, 0, 0, 0, 0, 0, 7, // Message ID (7)
+		0, 0, 0, 3, // Length of the editor's username (3 bytes)
+		'b', 'o', 'b', // Editor's username
+		0, 0, 0, 5, // Length of the payload (5 bytes)
+		'f', 'i', 'x', 'e', 'd', // Payload
+	}
+
+	encoded := edit.Encode()
+	if !bytes.Equal(encoded, expected) {
+		t.Errorf("Encode() = %v, want %v", encoded, expected)
+	}
+
+	var received MessageEdit
+	if err := received.Receive(bytes.NewReader(encoded)); err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+
+	if received != edit {
+		t.Errorf("Receive() = %v, want %v", received, edit)
+	}
+}
+
+func TestMessageDelete_EncodeReceive(t *testing.T) {
+	del := MessageDelete{ID: 7, DeletedBy: "bob"}
+
+	expected := []byte{
+		0, 0, 0, 0, 0, 0, 0, 7, // Message ID (7)
+		0, 0, 0, 3, // Length of the username (3 bytes)
+		'b', 'o', 'b', // Username
+	}
+
+	encoded := del.Encode()
+	if !bytes.Equal(encoded, expected) {
+		t.Errorf("Encode() = %v, want %v", encoded, expected)
+	}
+
+	var received MessageDelete
+	if err := received.Receive(bytes.NewReader(encoded)); err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+
+	if received != del {
+		t.Errorf("Receive() = %v, want %v", received, del)
+	}
+}
+
+func TestReaction_EncodeReceive(t *testing.T) {
+	re := Reaction{MessageID: 7, Username: "bob", Emoji: "👍", Add: true}
+
+	expected := []byte{
+		0, 0, 0, 0, 0, 0, 0, 7, // Message ID (7)
+		0, 0, 0, 3, // Length of the username (3 bytes)
+		'b', 'o', 'b', // Username
+		0, 0, 0, 4, // Length of the emoji (4 bytes)
+		0xf0, 0x9f, 0x91, 0x8d, // Emoji
+		1, // Add (true)
+	}
+
+	encoded := re.Encode()
+	if !bytes.Equal(encoded, expected) {
+		t.Errorf("Encode() = %v, want %v", encoded, expected)
+	}
+
+	var received Reaction
+	if err := received.Receive(bytes.NewReader(encoded)); err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+
+	if received != re {
+		t.Errorf("Receive() = %v, want %v", received, re)
+	}
+}
+
+func TestMentions(t *testing.T) {
+	mentions := Mentions("@alice can you ask @bob, and @alice again? email@example.com @ @")
+
+	assert.Equal(t, []string{"alice", "bob"}, mentions)
+}
+
+func TestMentionList_EncodeReceive(t *testing.T) {
+	ml := MentionList{Messages: []Message{
+		{ID: 1, From: "alice", Payload: "hi @bob", Timestamp: time.Unix(256, 0)},
+		{ID: 4, ParentID: 1, From: "carol", Payload: "@bob ping", Timestamp: time.Unix(257, 0), Edited: true},
+	}}
+
+	var received MentionList
+	if err := received.Receive(bytes.NewReader(ml.Encode())); err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+
+	assert.Equal(t, ml, received)
+}
+
+func TestSearchRequest_EncodeReceive(t *testing.T) {
+	sr := SearchRequest{Query: "deploy link", From: "alice", Since: time.Unix(256, 0), Offset: 20, Limit: 10}
+
+	var received SearchRequest
+	if err := received.Receive(bytes.NewReader(sr.Encode())); err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+
+	assert.Equal(t, sr, received)
+	assert.True(t, received.Until.IsZero())
+}
+
+func TestSearchResults_EncodeReceive(t *testing.T) {
+	sr := SearchResults{Query: "deploy", Total: 21, Offset: 20, Messages: []Message{
+		{ID: 1, From: "alice", Payload: "deploy done", Timestamp: time.Unix(256, 0)},
+	}}
+
+	var received SearchResults
+	if err := received.Receive(bytes.NewReader(sr.Encode())); err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+
+	assert.Equal(t, sr, received)
+}
+
+func TestFilePackets_EncodeReceive(t *testing.T) {
+	tests := []Packet{
+		&FileOffer{ID: 3, From: "alice", To: "bob", Name: "report.pdf", Size: 1 << 20, Checksum: sha256.Sum256([]byte("report"))},
+		&FileAccept{ID: 3, Username: "bob"},
+		&FileChunk{ID: 3, Offset: FileChunkSize, Data: []byte("some bytes")},
+		&FileComplete{ID: 3},
+		&FileCancel{ID: 3, Username: "bob", Reason: "changed my mind"},
+	}
+
+	for _, p := range tests {
+		received, err := ReadFrame(bytes.NewReader(Frame(p)))
+		if err != nil {
+			t.Fatalf("ReadFrame(%s) error = %v", p, err)
+		}
+
+		assert.Equal(t, p, received)
+	}
+}
+
+func TestFileChunk_TooLarge(t *testing.T) {
+	chunk := &FileChunk{ID: 1, Data: make([]byte, FileChunkSize+1)}
+
+	var received FileChunk
+	assert.Error(t, received.Receive(bytes.NewReader(chunk.Encode())))
+}
+
+func TestReceive_FieldLongerThanFrame(t *testing.T) {
+	// Each frame claims a 4 GiB field, which must be refused before it is
+	// allocated
+	huge := []byte{0xff, 0xff, 0xff, 0xff}
+	frames := map[Type][]byte{
+		TypeCommand:           huge,
+		TypeMessage:           append(make([]byte, 16), huge...),
+		TypeHandshakeResponse: append([]byte{0, 0, 0, 1}, huge...),
+		TypeSearchRequest:     huge,
+	}
+
+	for typ, body := range frames {
+		frame := append([]byte{byte(typ), 0, 0, 0, byte(len(body))}, body...)
+		_, err := ReadFrame(bytes.NewReader(frame))
+		assert.ErrorContains(t, err, "field of 4294967295 bytes exceeds its frame", typ)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	sent := time.Date(2025, 3, 1, 12, 0, 0, 256, time.UTC)
+	tests := []Packet{
+		&Handshake{Username: "alice", Version: ProtocolVersion},
+		&Message{ID: 7, From: "alice", Payload: "hi", Timestamp: sent, ServerTime: sent.Add(time.Millisecond)},
+		&Presence{Username: "bob", Status: true},
+		&FileChunk{ID: 3, Data: []byte{0, 1, 2}},
+	}
+
+	for _, p := range tests {
+		data, err := MarshalJSON(p)
+		if err != nil {
+			t.Fatalf("MarshalJSON(%s) error = %v", p, err)
+		}
+
+		received, err := UnmarshalJSON(data)
+		if err != nil {
+			t.Fatalf("UnmarshalJSON(%s) error = %v", data, err)
+		}
+
+		assert.Equal(t, p, received)
+	}
+
+	received, err := UnmarshalJSON([]byte(`{"type": "message", "packet": {"Payload": "from a browser"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, &Message{Payload: "from a browser"}, received)
+
+	var unknown *UnknownTypeError
+	_, err = UnmarshalJSON([]byte(`{"type": "hologram"}`))
+	assert.True(t, errors.As(err, &unknown), "unknown types are reported as such")
+
+	for typ := TypeHandshake; typ < TypeCompressed; typ++ {
+		assert.Contains(t, typeNames, typ, "every packet type has a JSON name")
+	}
+}
@@ -11,6 +11,10 @@ type Presence struct {
 	Status   bool
 }
 
+func (p *Presence) Type() Type {
+	return TypePresence
+}
+
 func (p *Presence) Encode() []byte {
 	usernameLength := uint32(len(p.Username))
 	packet := make([]byte, 5+usernameLength)
@@ -37,8 +41,8 @@ func (p *Presence) Receive(r io.Reader) error {
 	usernameLength := binary.BigEndian.Uint32(lengthBytes)
 
 	// Read the username based on the length
-	usernameBytes := make([]byte, usernameLength)
-	if _, err := io.ReadFull(r, usernameBytes); err != nil {
+	usernameBytes, err := receiveBytes(r, usernameLength)
+	if err != nil {
 		return err
 	}
 	username := string(usernameBytes)
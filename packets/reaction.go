@@ -0,0 +1,83 @@
+package packets
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Reaction adds or removes an emoji reaction of a user on a message.
+type Reaction struct {
+	MessageID uint64
+	Username  string
+	Emoji     string
+	Add       bool
+}
+
+func (re *Reaction) Type() Type {
+	return TypeReaction
+}
+
+func (re *Reaction) String() string {
+	return fmt.Sprintf("Reaction: user %s reacted %s on #%d (add %v)", re.Username, re.Emoji, re.MessageID, re.Add)
+}
+
+func (re *Reaction) Encode() []byte {
+	usernameLength := uint32(len(re.Username))
+	emojiLength := uint32(len(re.Emoji))
+	packet := make([]byte, 17+usernameLength+emojiLength)
+
+	binary.BigEndian.PutUint64(packet[0:8], re.MessageID)
+	binary.BigEndian.PutUint32(packet[8:12], usernameLength)
+	copy(packet[12:12+usernameLength], []byte(re.Username))
+	binary.BigEndian.PutUint32(packet[12+usernameLength:16+usernameLength], emojiLength)
+	copy(packet[16+usernameLength:16+usernameLength+emojiLength], []byte(re.Emoji))
+
+	if re.Add {
+		packet[len(packet)-1] = 1
+	}
+
+	return packet
+}
+
+func (re *Reaction) Receive(r io.Reader) error {
+	// Read the first 8 bytes to get the message ID
+	idBytes := make([]byte, 8)
+	if _, err := io.ReadFull(r, idBytes); err != nil {
+		return err
+	}
+
+	// Read the next 4 bytes to get the length of the username
+	lengthBytes := make([]byte, 4)
+	if _, err := io.ReadFull(r, lengthBytes); err != nil {
+		return err
+	}
+
+	usernameBytes, err := receiveBytes(r, binary.BigEndian.Uint32(lengthBytes))
+	if err != nil {
+		return err
+	}
+
+	// Read the next 4 bytes to get the length of the emoji
+	if _, err := io.ReadFull(r, lengthBytes); err != nil {
+		return err
+	}
+
+	emojiBytes, err := receiveBytes(r, binary.BigEndian.Uint32(lengthBytes))
+	if err != nil {
+		return err
+	}
+
+	// Read the add flag
+	addByte := make([]byte, 1)
+	if _, err := io.ReadFull(r, addByte); err != nil {
+		return err
+	}
+
+	re.MessageID = binary.BigEndian.Uint64(idBytes)
+	re.Username = string(usernameBytes)
+	re.Emoji = string(emojiBytes)
+	re.Add = addByte[0] == 1
+
+	return nil
+}
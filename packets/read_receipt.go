@@ -0,0 +1,54 @@
+package packets
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// ReadReceipt carries the ID of the last message a user has viewed.
+type ReadReceipt struct {
+	Username  string
+	MessageID uint64
+}
+
+func (rr *ReadReceipt) Type() Type {
+	return TypeReadReceipt
+}
+
+func (rr *ReadReceipt) String() string {
+	return fmt.Sprintf("ReadReceipt: user %s read up to #%d", rr.Username, rr.MessageID)
+}
+
+func (rr *ReadReceipt) Encode() []byte {
+	usernameLength := uint32(len(rr.Username))
+	packet := make([]byte, 12+usernameLength)
+	binary.BigEndian.PutUint32(packet[0:4], usernameLength)
+	copy(packet[4:4+usernameLength], []byte(rr.Username))
+	binary.BigEndian.PutUint64(packet[4+usernameLength:], rr.MessageID)
+	return packet
+}
+
+func (rr *ReadReceipt) Receive(r io.Reader) error {
+	// Read the first 4 bytes to get the length of the username
+	lengthBytes := make([]byte, 4)
+	if _, err := io.ReadFull(r, lengthBytes); err != nil {
+		return err
+	}
+
+	usernameBytes, err := receiveBytes(r, binary.BigEndian.Uint32(lengthBytes))
+	if err != nil {
+		return err
+	}
+
+	// Read the next 8 bytes to get the message ID
+	idBytes := make([]byte, 8)
+	if _, err := io.ReadFull(r, idBytes); err != nil {
+		return err
+	}
+
+	rr.Username = string(usernameBytes)
+	rr.MessageID = binary.BigEndian.Uint64(idBytes)
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package packets
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Rename announces that a user changed their username.
+type Rename struct {
+	From string
+	To   string
+}
+
+func (rn *Rename) Type() Type {
+	return TypeRename
+}
+
+func (rn *Rename) String() string {
+	return fmt.Sprintf("Rename: user %s is now %s", rn.From, rn.To)
+}
+
+func (rn *Rename) Encode() []byte {
+	fromLength := uint32(len(rn.From))
+	toLength := uint32(len(rn.To))
+	packet := make([]byte, 8+fromLength+toLength)
+
+	binary.BigEndian.PutUint32(packet[0:4], fromLength)
+	copy(packet[4:4+fromLength], []byte(rn.From))
+	binary.BigEndian.PutUint32(packet[4+fromLength:8+fromLength], toLength)
+	copy(packet[8+fromLength:], []byte(rn.To))
+
+	return packet
+}
+
+func (rn *Rename) Receive(r io.Reader) error {
+	// Read the first 4 bytes to get the length of the old username
+	lengthBytes := make([]byte, 4)
+	if _, err := io.ReadFull(r, lengthBytes); err != nil {
+		return err
+	}
+
+	fromBytes, err := receiveBytes(r, binary.BigEndian.Uint32(lengthBytes))
+	if err != nil {
+		return err
+	}
+
+	// Read the next 4 bytes to get the length of the new username
+	if _, err := io.ReadFull(r, lengthBytes); err != nil {
+		return err
+	}
+
+	toBytes, err := receiveBytes(r, binary.BigEndian.Uint32(lengthBytes))
+	if err != nil {
+		return err
+	}
+
+	rn.From = string(fromBytes)
+	rn.To = string(toBytes)
+
+	return nil
+}
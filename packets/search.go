@@ -0,0 +1,106 @@
+package packets
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// SearchRequest asks the server for the stored messages matching a full-text
+// query. Empty filters match everything.
+type SearchRequest struct {
+	Query  string
+	From   string
+	Since  time.Time
+	Until  time.Time
+	Offset uint32
+	Limit  uint32
+}
+
+func (sr *SearchRequest) Type() Type {
+	return TypeSearchRequest
+}
+
+func (sr *SearchRequest) String() string {
+	return fmt.Sprintf("SearchRequest: %q from %q (offset %d, limit %d)", sr.Query, sr.From, sr.Offset, sr.Limit)
+}
+
+func (sr *SearchRequest) Encode() []byte {
+	var packet []byte
+	packet = appendString(packet, sr.Query)
+	packet = appendString(packet, sr.From)
+	packet = appendTime(packet, sr.Since)
+	packet = appendTime(packet, sr.Until)
+	packet = binary.BigEndian.AppendUint32(packet, sr.Offset)
+	packet = binary.BigEndian.AppendUint32(packet, sr.Limit)
+	return packet
+}
+
+func (sr *SearchRequest) Receive(r io.Reader) error {
+	var err error
+	if sr.Query, err = receiveString(r); err != nil {
+		return err
+	}
+	if sr.From, err = receiveString(r); err != nil {
+		return err
+	}
+	if sr.Since, err = receiveTime(r); err != nil {
+		return err
+	}
+	if sr.Until, err = receiveTime(r); err != nil {
+		return err
+	}
+
+	// Read the last 8 bytes to get the offset and the limit
+	pageBytes := make([]byte, 8)
+	if _, err := io.ReadFull(r, pageBytes); err != nil {
+		return err
+	}
+	sr.Offset = binary.BigEndian.Uint32(pageBytes[0:4])
+	sr.Limit = binary.BigEndian.Uint32(pageBytes[4:8])
+
+	return nil
+}
+
+// SearchResults is one page of the messages matching a SearchRequest, newest
+// first.
+type SearchResults struct {
+	Query    string
+	Total    uint32
+	Offset   uint32
+	Messages []Message
+}
+
+func (sr *SearchResults) Type() Type {
+	return TypeSearchResults
+}
+
+func (sr *SearchResults) String() string {
+	return fmt.Sprintf("SearchResults: %q %d of %d from offset %d", sr.Query, len(sr.Messages), sr.Total, sr.Offset)
+}
+
+func (sr *SearchResults) Encode() []byte {
+	packet := appendString(nil, sr.Query)
+	packet = binary.BigEndian.AppendUint32(packet, sr.Total)
+	packet = binary.BigEndian.AppendUint32(packet, sr.Offset)
+	return encodeMessages(packet, sr.Messages)
+}
+
+func (sr *SearchResults) Receive(r io.Reader) error {
+	var err error
+	if sr.Query, err = receiveString(r); err != nil {
+		return err
+	}
+
+	// Read the next 8 bytes to get the total and the offset
+	pageBytes := make([]byte, 8)
+	if _, err := io.ReadFull(r, pageBytes); err != nil {
+		return err
+	}
+	sr.Total = binary.BigEndian.Uint32(pageBytes[0:4])
+	sr.Offset = binary.BigEndian.Uint32(pageBytes[4:8])
+
+	sr.Messages, err = receiveMessages(r)
+	return err
+}
@@ -0,0 +1,72 @@
+package packets
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Topic carries the topic of a room, sent on login and whenever it changes.
+type Topic struct {
+	Room  string
+	Text  string
+	SetBy string
+	SetAt time.Time
+}
+
+func (t *Topic) Type() Type {
+	return TypeTopic
+}
+
+func (t *Topic) String() string {
+	return fmt.Sprintf("Topic: room %s set by %s at %s", t.Room, t.SetBy, t.SetAt)
+}
+
+func (t *Topic) Encode() []byte {
+	roomLength := uint32(len(t.Room))
+	textLength := uint32(len(t.Text))
+	setByLength := uint32(len(t.SetBy))
+
+	packet := make([]byte, 20+roomLength+textLength+setByLength)
+
+	offset := uint32(0)
+	for _, field := range []string{t.Room, t.Text, t.SetBy} {
+		binary.BigEndian.PutUint32(packet[offset:offset+4], uint32(len(field)))
+		copy(packet[offset+4:], []byte(field))
+		offset += 4 + uint32(len(field))
+	}
+	binary.BigEndian.PutUint64(packet[offset:], unixNano(t.SetAt))
+
+	return packet
+}
+
+func (t *Topic) Receive(r io.Reader) error {
+	// Read the room, text and author, each prefixed by its 4 bytes length
+	fields := make([]string, 3)
+	lengthBytes := make([]byte, 4)
+	for i := range fields {
+		if _, err := io.ReadFull(r, lengthBytes); err != nil {
+			return err
+		}
+
+		fieldBytes, err := receiveBytes(r, binary.BigEndian.Uint32(lengthBytes))
+		if err != nil {
+			return err
+		}
+		fields[i] = string(fieldBytes)
+	}
+
+	// Read the next 8 bytes to get the timestamp
+	timestampBytes := make([]byte, 8)
+	if _, err := io.ReadFull(r, timestampBytes); err != nil {
+		return err
+	}
+
+	t.Room = fields[0]
+	t.Text = fields[1]
+	t.SetBy = fields[2]
+	t.SetAt = fromUnixNano(binary.BigEndian.Uint64(timestampBytes))
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package packets
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Typing signals that a user started or stopped typing a message. It is
+// relayed by the server but never stored.
+type Typing struct {
+	Username string
+	Typing   bool
+}
+
+func (t *Typing) Type() Type {
+	return TypeTyping
+}
+
+func (t *Typing) Encode() []byte {
+	usernameLength := uint32(len(t.Username))
+	packet := make([]byte, 5+usernameLength)
+	binary.BigEndian.PutUint32(packet[0:4], usernameLength)
+	copy(packet[4:], []byte(t.Username))
+
+	if t.Typing {
+		packet[len(packet)-1] = 1
+	}
+
+	return packet
+}
+
+func (t *Typing) Receive(r io.Reader) error {
+	// Read the first 4 bytes to get the length of the username
+	lengthBytes := make([]byte, 4)
+	if _, err := io.ReadFull(r, lengthBytes); err != nil {
+		return err
+	}
+
+	usernameBytes, err := receiveBytes(r, binary.BigEndian.Uint32(lengthBytes))
+	if err != nil {
+		return err
+	}
+
+	// Read the typing flag
+	flagByte := make([]byte, 1)
+	if _, err := io.ReadFull(r, flagByte); err != nil {
+		return err
+	}
+
+	t.Username = string(usernameBytes)
+	t.Typing = flagByte[0] == 1
+
+	return nil
+}
+
+func (t *Typing) String() string {
+	return fmt.Sprintf("Typing: user %s is typing %v", t.Username, t.Typing)
+}
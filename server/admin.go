@@ -0,0 +1,352 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/root-man/chat/packets"
+)
+
+// adminName is who administrators kick and ban users as.
+const adminName = "an administrator"
+
+// AdminRequest is a command sent to the admin console, one JSON object per
+// line.
+type AdminRequest struct {
+	Command string `json:"command"`
+	Args    string `json:"args"`
+}
+
+// AdminResponse answers an AdminRequest, with either the output of the
+// command or why it failed.
+type AdminResponse struct {
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type adminCommand struct {
+	usage       string
+	description string
+	run         func(s *Server, args string) (string, error)
+}
+
+// adminCommands is the registry of the commands of the admin console.
+var adminCommands map[string]adminCommand
+
+func init() {
+	// Set in init as help lists the registry itself
+	adminCommands = map[string]adminCommand{
+		"help": {
+			usage:       "help",
+			description: "list the commands",
+			run:         (*Server).adminHelp,
+		},
+		"sessions": {
+			usage:       "sessions",
+			description: "list the connected users with their address and connection time",
+			run:         (*Server).adminSessions,
+		},
+		"announce": {
+			usage:       "announce <text>",
+			description: "send a message from the server to every user",
+			run:         (*Server).adminAnnounce,
+		},
+		"kick": {
+			usage:       "kick <user> [reason]",
+			description: "disconnect a user",
+			run:         (*Server).adminKick,
+		},
+		"ban": {
+			usage:       "ban <user or IP address> [reason]",
+			description: "refuse a user or address until the server stops, disconnecting them",
+			run:         (*Server).adminBan,
+		},
+		"unban": {
+			usage:       "unban <user or IP address>",
+			description: "lift a ban made from the console",
+			run:         (*Server).adminUnban,
+		},
+		"bans": {
+			usage:       "bans",
+			description: "list the bans",
+			run:         (*Server).adminBans,
+		},
+		"queues": {
+			usage:       "queues",
+			description: "show the writes waiting on each connection and the webhook queues",
+			run:         (*Server).adminQueues,
+		},
+		"reload": {
+			usage:       "reload",
+			description: "read the configuration file again",
+			run:         func(s *Server, _ string) (string, error) { return "Configuration reloaded", s.Reload() },
+		},
+	}
+}
+
+// listenAdmin opens the Unix socket of the admin console, which only the
+// user running the server may connect to.
+func listenAdmin(path string) (net.Listener, error) {
+	// A socket left behind by a previous run would prevent listening, but
+	// one still answering belongs to a running server
+	if info, err := os.Stat(path); err == nil && info.Mode().Type() == fs.ModeSocket {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("admin socket %s is in use by a running server", path)
+		}
+		os.Remove(path)
+	}
+
+	// The socket is made in a directory only the user can enter, and moved
+	// into place once nobody else may connect to it
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".admin-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := net.Listen("unix", filepath.Join(dir, "admin.sock"))
+	if err != nil {
+		return nil, err
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(filepath.Join(dir, "admin.sock"), 0o600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Rename(filepath.Join(dir, "admin.sock"), path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return &adminListener{l, path}, nil
+}
+
+// adminListener removes the socket of the admin console when closed, as it
+// was moved away from where it was bound.
+type adminListener struct {
+	net.Listener
+	path string
+}
+
+func (l *adminListener) Close() error {
+	os.Remove(l.path)
+	return l.Listener.Close()
+}
+
+func (s *Server) serveAdmin() {
+	for {
+		conn, err := s.admin.Accept()
+		if err != nil {
+			logger.Error("Admin console stopped", "err", err)
+			return
+		}
+		go s.handleAdmin(conn)
+	}
+}
+
+func (s *Server) handleAdmin(conn net.Conn) {
+	defer conn.Close()
+
+	lines := bufio.NewScanner(conn)
+	encoder := json.NewEncoder(conn)
+	for lines.Scan() {
+		var req AdminRequest
+		var resp AdminResponse
+		if err := json.Unmarshal(lines.Bytes(), &req); err != nil {
+			resp.Error = fmt.Sprintf("invalid request: %s", err)
+		} else if output, err := s.runAdmin(&req); err != nil {
+			resp.Error = err.Error()
+		} else {
+			resp.Output = output
+		}
+
+		if err := encoder.Encode(&resp); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) runAdmin(req *AdminRequest) (string, error) {
+	c, ok := adminCommands[req.Command]
+	if !ok {
+		return "", fmt.Errorf("unknown command %q, try help", req.Command)
+	}
+
+	logger.Info("Admin command", "command", req.Command, "args", req.Args)
+	return c.run(s, strings.TrimSpace(req.Args))
+}
+
+func (s *Server) adminHelp(_ string) (string, error) {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	for _, name := range slices.Sorted(maps.Keys(adminCommands)) {
+		fmt.Fprintf(w, "%s\t%s\n", adminCommands[name].usage, adminCommands[name].description)
+	}
+	w.Flush()
+	return b.String(), nil
+}
+
+func (s *Server) adminSessions(_ string) (string, error) {
+	s.mu.Lock()
+	sessions := slices.Collect(maps.Values(s.conns))
+	s.mu.Unlock()
+	slices.SortFunc(sessions, func(a, b *session) int { return a.connected.Compare(b.connected) })
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tTRANSPORT\tADDRESS\tCONNECTED\tFOR")
+	for _, sess := range sessions {
+		s.mu.Lock()
+		name := sess.name
+		s.mu.Unlock()
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", name, transportName(sess.conn), sess.conn.remoteAddr(), sess.connected.Format(time.DateTime), time.Since(sess.connected).Round(time.Second))
+	}
+	w.Flush()
+	return fmt.Sprintf("%d users online\n%s", len(sessions), b.String()), nil
+}
+
+func transportName(t transport) string {
+	switch t.(type) {
+	case *tcpTransport:
+		return "tcp"
+	case *wsTransport:
+		return "websocket"
+	case *ircTransport:
+		return "irc"
+	}
+	return "unknown"
+}
+
+func (s *Server) adminAnnounce(text string) (string, error) {
+	if text == "" {
+		return "", errors.New("usage: announce <text>")
+	}
+
+	to := s.onlineUsers()
+	msg := &packets.Message{From: systemUser, Payload: text, Timestamp: time.Now()}
+	if err := s.multicast(msg, to); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Announced to %d users", len(to)), nil
+}
+
+func (s *Server) adminKick(args string) (string, error) {
+	username, reason, _ := strings.Cut(args, " ")
+	if username == "" {
+		return "", errors.New("usage: kick <user> [reason]")
+	}
+
+	if err := s.kick(username, adminName, strings.TrimSpace(reason)); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Kicked %s", username), nil
+}
+
+func (s *Server) adminBan(args string) (string, error) {
+	target, reason, _ := strings.Cut(args, " ")
+	if target == "" {
+		return "", errors.New("usage: ban <user or IP address> [reason]")
+	}
+
+	reason = strings.TrimSpace(reason)
+	s.mu.Lock()
+	if s.bans == nil {
+		s.bans = make(map[string]string)
+	}
+	s.bans[strings.ToLower(target)] = reason
+	s.mu.Unlock()
+
+	kicked := s.kickBanned(reason)
+	return fmt.Sprintf("Banned %s, disconnecting %d users", target, kicked), nil
+}
+
+// kickBanned disconnects the online users who are banned, returning how many
+// there were.
+func (s *Server) kickBanned(reason string) int {
+	return s.kickIf(func(name string, sess *session) bool { return s.banned(name, sess.conn.remoteAddr()) }, reason)
+}
+
+// kickIf disconnects the online users refused tells apart, returning how many
+// there were. refused is called while holding s.mu.
+func (s *Server) kickIf(refused func(name string, sess *session) bool, reason string) int {
+	s.mu.Lock()
+	var kicked []string
+	for name, sess := range s.conns {
+		if refused(name, sess) {
+			kicked = append(kicked, name)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, username := range kicked {
+		s.kick(username, adminName, reason)
+	}
+	return len(kicked)
+}
+
+func (s *Server) adminUnban(target string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.bans[strings.ToLower(target)]; !ok {
+		if slices.ContainsFunc(s.settings().Bans, func(ban string) bool { return strings.EqualFold(ban, target) }) {
+			return "", fmt.Errorf("%s is banned by the configuration file", target)
+		}
+		return "", fmt.Errorf("%s is not banned", target)
+	}
+	delete(s.bans, strings.ToLower(target))
+	return fmt.Sprintf("Unbanned %s", target), nil
+}
+
+func (s *Server) adminBans(_ string) (string, error) {
+	var b strings.Builder
+	for _, ban := range s.settings().Bans {
+		fmt.Fprintf(&b, "%s (configuration file)\n", ban)
+	}
+
+	s.mu.Lock()
+	for _, ban := range slices.Sorted(maps.Keys(s.bans)) {
+		if reason := s.bans[ban]; reason != "" {
+			fmt.Fprintf(&b, "%s (console: %s)\n", ban, reason)
+		} else {
+			fmt.Fprintf(&b, "%s (console)\n", ban)
+		}
+	}
+	s.mu.Unlock()
+
+	if b.Len() == 0 {
+		return "No bans", nil
+	}
+	return b.String(), nil
+}
+
+func (s *Server) adminQueues(_ string) (string, error) {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "QUEUE\tPENDING")
+
+	s.mu.Lock()
+	for _, name := range slices.Sorted(maps.Keys(s.conns)) {
+		fmt.Fprintf(w, "user %s\t%d\n", name, s.conns[name].pending.Load())
+	}
+	s.mu.Unlock()
+
+	s.hooksMu.RLock()
+	defer s.hooksMu.RUnlock()
+	for _, sender := range s.webhooks {
+		fmt.Fprintf(w, "webhook %s\t%d/%d\n", sender.URL, len(sender.queue), cap(sender.queue))
+	}
+	w.Flush()
+	return b.String(), nil
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/root-man/chat/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmin(t *testing.T) {
+	s := newTestServer(t)
+	s.config.Store(&Config{Bans: []string{"mallory"}})
+	_, toAlice := connect(t, s, "alice")
+	_, toBob := connect(t, s, "bob")
+
+	socket := filepath.Join(t.TempDir(), "admin.sock")
+	l, err := listenAdmin(socket)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	s.admin = l
+	_, err = listenAdmin(socket)
+	assert.EqualError(t, err, "admin socket "+socket+" is in use by a running server")
+	info, err := os.Stat(socket)
+	require.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0o600), info.Mode().Perm())
+	entries, err := os.ReadDir(filepath.Dir(socket))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "the directory the socket was made in is removed")
+	go s.serveAdmin()
+
+	conn, err := net.Dial("unix", socket)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	responses := bufio.NewScanner(conn)
+	run := func(command, args string) AdminResponse {
+		require.NoError(t, json.NewEncoder(conn).Encode(&AdminRequest{Command: command, Args: args}))
+		require.True(t, responses.Scan())
+		var resp AdminResponse
+		require.NoError(t, json.Unmarshal(responses.Bytes(), &resp))
+		return resp
+	}
+
+	assert.Contains(t, run("sessions", "").Output, "2 users online")
+	assert.Equal(t, `unknown command "shutdown", try help`, run("shutdown", "").Error)
+
+	assert.Equal(t, "Announced to 2 users", run("announce", "restarting in 5 minutes").Output)
+	assert.Equal(t, "restarting in 5 minutes", (<-toAlice).(*packets.Message).Payload)
+	assert.Equal(t, "restarting in 5 minutes", (<-toBob).(*packets.Message).Payload)
+
+	assert.Equal(t, "Banned 192.0.2.7, disconnecting 0 users", run("ban", "192.0.2.7").Output)
+	assert.Equal(t, "Banned Bob, disconnecting 1 users", run("ban", "Bob flooding").Output)
+	assert.Equal(t, "You were kicked by an administrator: flooding", (<-toBob).(*packets.Message).Payload)
+	assert.Equal(t, "bob was kicked by an administrator: flooding", (<-toAlice).(*packets.Message).Payload)
+	s.mu.Lock()
+	assert.True(t, s.banned("Bob", &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1)}))
+	assert.True(t, s.banned("carol", &net.TCPAddr{IP: net.IPv4(192, 0, 2, 7), Port: 5000}))
+	s.mu.Unlock()
+	assert.Equal(t, "mallory (configuration file)\n192.0.2.7 (console)\nbob (console: flooding)\n", run("bans", "").Output)
+	assert.Equal(t, "Mallory is banned by the configuration file", run("unban", "Mallory").Error)
+	assert.Equal(t, "Unbanned bob", run("unban", "bob").Output, "names are unbanned regardless of case")
+	assert.Equal(t, "BOB is not banned", run("unban", "BOB").Error)
+
+	assert.Equal(t, "the server was not started with a configuration file", run("reload", "").Error)
+	s.SetConfigLoader(func() (Config, error) { return Config{MOTD: "welcome"}, nil })
+	assert.Equal(t, "Configuration reloaded", run("reload", "").Output)
+	assert.Equal(t, "welcome", s.settings().MOTD)
+	assert.Empty(t, s.settings().Bans)
+}
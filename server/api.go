@@ -0,0 +1,260 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/root-man/chat/packets"
+)
+
+// minAPITokenLength keeps API tokens from being guessable.
+const minAPITokenLength = 16
+
+// apiMessage is the form of a message in the HTTP API.
+type apiMessage struct {
+	ID       uint64    `json:"id"`
+	ParentID uint64    `json:"parent_id,omitempty"`
+	From     string    `json:"from"`
+	Text     string    `json:"text"`
+	Time     time.Time `json:"time"`
+	Edited   bool      `json:"edited,omitempty"`
+}
+
+func newAPIMessage(m *packets.Message) apiMessage {
+	return apiMessage{ID: m.ID, ParentID: m.ParentID, From: m.From, Text: m.Payload, Time: m.Time(), Edited: m.Edited}
+}
+
+type apiRoom struct {
+	Name       string     `json:"name"`
+	Topic      string     `json:"topic,omitempty"`
+	TopicSetBy string     `json:"topic_set_by,omitempty"`
+	TopicSetAt *time.Time `json:"topic_set_at,omitempty"`
+	Users      int        `json:"users"`
+}
+
+// serveAPI runs the HTTP API letting other tools integrate with the chat.
+func (s *Server) serveAPI() {
+	server := &http.Server{Handler: s.apiHandler(), ReadHeaderTimeout: 10 * time.Second}
+	if err := server.Serve(s.api); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		apiLogger.Error("HTTP API stopped", "err", err)
+	}
+}
+
+func (s *Server) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/users", s.authenticate(s.apiUsers))
+	mux.HandleFunc("GET /api/rooms", s.authenticate(s.apiRooms))
+	mux.HandleFunc("GET /api/messages", s.authenticate(s.apiHistory))
+	mux.HandleFunc("POST /api/messages", s.authenticate(s.apiPost))
+	mux.HandleFunc("POST /api/users/{name}/kick", s.authenticate(s.apiKick))
+	return mux
+}
+
+// authenticate passes the requests bearing a valid API token to handler,
+// along with the name of the bot the token belongs to.
+func (s *Server) authenticate(handler func(w http.ResponseWriter, r *http.Request, bot string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		bot := s.apiBot(token)
+		if !ok || bot == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="chat"`)
+			apiError(w, http.StatusUnauthorized, "missing or invalid API token")
+			return
+		}
+
+		handler(w, r, bot)
+	}
+}
+
+// apiBot returns the bot a token belongs to, if any.
+func (s *Server) apiBot(token string) string {
+	bot := ""
+	for name, t := range s.settings().APITokens {
+		// Every token is compared so that timing does not tell them apart
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			bot = name
+		}
+	}
+	return bot
+}
+
+func (s *Server) apiUsers(w http.ResponseWriter, _ *http.Request, _ string) {
+	writeJSON(w, http.StatusOK, map[string][]string{"users": s.onlineUsers()})
+}
+
+func (s *Server) apiRooms(w http.ResponseWriter, _ *http.Request, _ string) {
+	users := len(s.onlineUsers())
+
+	s.mu.Lock()
+	var rooms []apiRoom
+	for _, r := range s.rooms {
+		// Every user is in every room for now
+		room := apiRoom{Name: r.name, Topic: r.topic, TopicSetBy: r.topicSetBy, Users: users}
+		if r.topic != "" {
+			setAt := r.topicSetAt
+			room.TopicSetAt = &setAt
+		}
+		rooms = append(rooms, room)
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(rooms, func(a, b apiRoom) int { return strings.Compare(a.Name, b.Name) })
+	writeJSON(w, http.StatusOK, map[string][]apiRoom{"rooms": rooms})
+}
+
+// apiHistory returns the messages of the history, newest first. It takes the
+// filters of searches as query parameters: q, from, since, until, offset and
+// limit, times being in RFC 3339.
+func (s *Server) apiHistory(w http.ResponseWriter, r *http.Request, _ string) {
+	query := r.URL.Query()
+	req := &packets.SearchRequest{Query: query.Get("q"), From: query.Get("from")}
+
+	var errs []error
+	parseTime := func(name string, t *time.Time) {
+		if value := query.Get(name); value != "" {
+			var err error
+			*t, err = time.Parse(time.RFC3339, value)
+			errs = append(errs, err)
+		}
+	}
+	parseUint := func(name string, n *uint32) {
+		if value := query.Get(name); value != "" {
+			parsed, err := strconv.ParseUint(value, 10, 32)
+			*n = uint32(parsed)
+			errs = append(errs, err)
+		}
+	}
+	parseTime("since", &req.Since)
+	parseTime("until", &req.Until)
+	parseUint("offset", &req.Offset)
+	parseUint("limit", &req.Limit)
+	if err := errors.Join(errs...); err != nil {
+		apiError(w, http.StatusBadRequest, "invalid query: "+err.Error())
+		return
+	}
+
+	messages, total := s.history.search(req)
+	result := struct {
+		Total    int          `json:"total"`
+		Offset   uint32       `json:"offset"`
+		Messages []apiMessage `json:"messages"`
+	}{Total: total, Offset: req.Offset, Messages: []apiMessage{}}
+	for _, m := range messages {
+		result.Messages = append(result.Messages, newAPIMessage(&m))
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// apiPost posts a message as the bot, optionally in the thread of parent_id.
+func (s *Server) apiPost(w http.ResponseWriter, r *http.Request, bot string) {
+	var body struct {
+		Text     string `json:"text"`
+		ParentID uint64 `json:"parent_id"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+	if strings.TrimSpace(body.Text) == "" {
+		apiError(w, http.StatusBadRequest, "text is required")
+		return
+	}
+
+	limit := s.settings().RateLimit
+	s.mu.Lock()
+	if s.apiRates == nil {
+		s.apiRates = make(map[string]*rateWindow)
+	}
+	if s.apiRates[bot] == nil {
+		s.apiRates[bot] = &rateWindow{}
+	}
+	allowed := s.apiRates[bot].allow(limit)
+	s.mu.Unlock()
+	if !allowed {
+		apiError(w, http.StatusTooManyRequests, fmt.Sprintf("bots can only send %d messages per minute", limit))
+		return
+	}
+
+	// Bots go through the same plugins as users
+	p, err := s.pluginsFilter(bot, &packets.Message{ParentID: body.ParentID, From: bot, Payload: body.Text, Timestamp: time.Now()})
+	if err != nil {
+		apiError(w, http.StatusUnprocessableEntity, "not sent: "+err.Error())
+		return
+	}
+	msg, ok := p.(*packets.Message)
+	if !ok {
+		apiError(w, http.StatusUnprocessableEntity, "not sent: replaced by a plugin")
+		return
+	}
+
+	if err := s.post(msg); err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	apiLogger.Info("Bot posted message", "bot", bot, "id", msg.ID)
+	writeJSON(w, http.StatusCreated, newAPIMessage(msg))
+}
+
+// apiKick disconnects a user, which only bots listed as moderators may do.
+func (s *Server) apiKick(w http.ResponseWriter, r *http.Request, bot string) {
+	if !s.isModerator(bot) {
+		apiError(w, http.StatusForbidden, "only moderators can kick users")
+		return
+	}
+
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 && !readJSON(w, r, &body) {
+		return
+	}
+
+	if err := s.kick(r.PathValue("name"), bot, body.Reason); err != nil {
+		apiError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isBot tells whether a name belongs to an API bot, which users cannot take.
+func (s *Server) isBot(name string) bool {
+	for bot := range s.settings().APITokens {
+		if strings.EqualFold(bot, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// readJSON decodes the body of a request into v, answering with an error if
+// it cannot.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, packets.MaxFrameSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		apiError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		apiLogger.Warn("Failed to write response", "err", err)
+	}
+}
+
+func apiError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
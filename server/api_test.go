@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/root-man/chat/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPI(t *testing.T) {
+	config := Config{Moderators: []string{"janitor"}, APITokens: map[string]string{"deploy": "deploy-secret-token", "janitor": "janitor-secret-token"}}
+	s := newTestServer(t)
+	s.config.Store(&config)
+	_, toAlice := connect(t, s, "alice")
+	_, toBob := connect(t, s, "bob")
+
+	api := httptest.NewServer(s.apiHandler())
+	defer api.Close()
+
+	request := func(method, path, token, body string) *http.Response {
+		req, err := http.NewRequest(method, api.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	decode := func(resp *http.Response, v any) {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/api/users", "", "").StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/api/users", "deploy-secret", "").StatusCode)
+
+	resp := request("GET", "/api/users", "deploy-secret-token", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var users struct{ Users []string }
+	decode(resp, &users)
+	assert.Equal(t, []string{"alice", "bob"}, users.Users)
+
+	resp = request("POST", "/api/messages", "deploy-secret-token", `{"text": "v1.2 is deployed"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var posted apiMessage
+	decode(resp, &posted)
+	assert.Equal(t, "deploy", posted.From)
+	received := (<-toAlice).(*packets.Message)
+	assert.Equal(t, posted.ID, received.ID)
+	assert.Equal(t, "v1.2 is deployed", received.Payload)
+	<-toBob
+
+	assert.Equal(t, http.StatusBadRequest, request("POST", "/api/messages", "deploy-secret-token", `{"txt": "typo"}`).StatusCode)
+
+	resp = request("GET", "/api/messages?q=deployed&limit=10", "deploy-secret-token", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var found struct {
+		Total    int
+		Messages []apiMessage
+	}
+	decode(resp, &found)
+	assert.Equal(t, 1, found.Total)
+	assert.Equal(t, posted.ID, found.Messages[0].ID)
+	assert.Equal(t, http.StatusBadRequest, request("GET", "/api/messages?since=yesterday", "deploy-secret-token", "").StatusCode)
+
+	assert.Equal(t, http.StatusForbidden, request("POST", "/api/users/bob/kick", "deploy-secret-token", "").StatusCode)
+	assert.Equal(t, http.StatusNotFound, request("POST", "/api/users/carol/kick", "janitor-secret-token", "").StatusCode)
+	assert.Equal(t, http.StatusNoContent, request("POST", "/api/users/bob/kick", "janitor-secret-token", `{"reason": "spam"}`).StatusCode)
+	assert.Equal(t, "You were kicked by janitor: spam", (<-toBob).(*packets.Message).Payload)
+	assert.Equal(t, "bob was kicked by janitor: spam", (<-toAlice).(*packets.Message).Payload)
+}
+
+func TestAPI_Limits(t *testing.T) {
+	filter, err := loadPlugins([]PluginConfig{{Name: "profanity-filter", Options: json.RawMessage(`{"words": ["heck"], "action": "drop"}`)}})
+	require.NoError(t, err)
+	s := newTestServer(t)
+	s.plugins = filter
+	s.config.Store(&Config{RateLimit: 2, APITokens: map[string]string{"deploy": "deploy-secret-token"}})
+	_, toAlice := connect(t, s, "alice")
+
+	api := httptest.NewServer(s.apiHandler())
+	defer api.Close()
+	post := func(text string) *http.Response {
+		req, err := http.NewRequest("POST", api.URL+"/api/messages", strings.NewReader(`{"text": "`+text+`"}`))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer deploy-secret-token")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	assert.Equal(t, http.StatusUnprocessableEntity, post("what the heck").StatusCode, "bots go through the plugins")
+	assert.Equal(t, http.StatusCreated, post("deployed").StatusCode)
+	assert.Equal(t, "deployed", (<-toAlice).(*packets.Message).Payload)
+	assert.Equal(t, http.StatusTooManyRequests, post("deployed again").StatusCode)
+
+	s.mu.Lock()
+	assert.ErrorIs(t, s.checkUsername("Deploy"), errUsernameInUse, "users cannot pose as bots")
+	s.mu.Unlock()
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/root-man/chat/packets"
+)
+
+// systemUser is the sender name used for messages generated by the server.
+const systemUser = "CHAT"
+
+type command struct {
+	usage       string
+	description string
+	run         func(s *Server, sess *session, args string) error
+	// relayed tells that the arguments are shown to other users as chat
+	// text, which plugins filtering messages and the rate limit also apply
+	// to.
+	relayed bool
+}
+
+// commands is the registry of server side slash commands. Adding a command
+// only takes a new entry here.
+var commands = map[string]command{
+	"who": {
+		usage:       "/who",
+		description: "list the users currently online",
+		run:         (*Server).cmdWho,
+	},
+	"me": {
+		usage:       "/me <action>",
+		description: "send an action, e.g. /me waves",
+		run:         (*Server).cmdMe,
+		relayed:     true,
+	},
+	"nick": {
+		usage:       "/nick <name>",
+		description: "change your username",
+		run:         (*Server).cmdNick,
+	},
+	"mentions": {
+		usage:       "/mentions",
+		description: "list the recent messages mentioning you",
+		run:         (*Server).cmdMentions,
+	},
+	"files": {
+		usage:       "/files",
+		description: "list the files stored on the server",
+		run:         (*Server).cmdFiles,
+	},
+	"topic": {
+		usage:       "/topic [text]",
+		description: "show or, for moderators, set the room topic",
+		run:         (*Server).cmdTopic,
+		relayed:     true,
+	},
+}
+
+func (s *Server) handleCommand(sess *session, cmd *packets.Command) {
+	logger.Info("User issued command", "user", sess.name, "command", cmd.Name)
+	s.emit(&webhookEvent{Event: eventCommand, User: sess.name, Command: cmd.Name, Args: cmd.Args})
+
+	c, ok := commands[cmd.Name]
+	if !ok {
+		s.notify(sess.name, fmt.Sprintf("Unknown command /%s", cmd.Name))
+		return
+	}
+
+	if limit := s.settings().RateLimit; c.relayed && cmd.Args != "" && !sess.messages.allow(limit) {
+		s.notify(sess.name, fmt.Sprintf("Not sent: you can only send %d messages per minute", limit))
+		return
+	}
+
+	if err := c.run(s, sess, cmd.Args); err != nil {
+		s.notify(sess.name, fmt.Sprintf("/%s: %s", cmd.Name, err))
+	}
+}
+
+// notify sends a message from the server to a single user.
+func (s *Server) notify(username string, text string) {
+	msg := &packets.Message{From: systemUser, Payload: text, Timestamp: time.Now()}
+	if err := s.send(msg, username); err != nil {
+		logger.Warn("Failed to notify user", "user", username, "err", err)
+	}
+}
+
+func (s *Server) onlineUsers() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Sorted(maps.Keys(s.conns))
+}
+
+func (s *Server) cmdWho(sess *session, _ string) error {
+	users := s.onlineUsers()
+	s.notify(sess.name, fmt.Sprintf("%d users online: %s", len(users), strings.Join(users, ", ")))
+	return nil
+}
+
+func (s *Server) cmdMe(sess *session, args string) error {
+	if args == "" {
+		return errors.New("usage: /me <action>")
+	}
+
+	msg := &packets.Message{From: systemUser, Payload: fmt.Sprintf("* %s %s", sess.name, args), Timestamp: time.Now()}
+	return s.multicast(msg, s.onlineUsers())
+}
+
+func (s *Server) cmdNick(sess *session, args string) error {
+	if args == "" {
+		return errors.New("usage: /nick <name>")
+	}
+
+	// Renaming follows the rules of joining under the new name
+	s.mu.Lock()
+	if err := s.checkUsername(args); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+
+	rename := &packets.Rename{From: sess.name, To: args}
+	delete(s.conns, sess.name)
+	sess.name = args
+	s.conns[sess.name] = sess
+	s.mu.Unlock()
+
+	logger.Info("User renamed", "from", rename.From, "to", rename.To)
+
+	if err := s.history.rename(rename.From, rename.To); err != nil {
+		logger.Error("Failed to store read position", "user", rename.To, "err", err)
+	}
+
+	return s.multicast(rename, s.onlineUsers())
+}
+
+func (s *Server) cmdMentions(sess *session, _ string) error {
+	messages, _ := s.history.mentions(sess.name)
+	return s.send(&packets.MentionList{Messages: messages}, sess.name)
+}
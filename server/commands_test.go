@@ -0,0 +1,41 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/root-man/chat/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNick(t *testing.T) {
+	s := newTestServer(t)
+	s.config.Store(&Config{APITokens: map[string]string{"deploybot": "deploy-secret-token"}})
+	require.NoError(t, s.history.append(&packets.Message{From: "mallory", Payload: "hello"}))
+	mallory, _ := connect(t, s, "mallory")
+	connect(t, s, "alice")
+
+	// Renaming follows the same rules as joining
+	assert.ErrorIs(t, s.cmdNick(mallory, "alice"), errUsernameInUse)
+	assert.ErrorIs(t, s.cmdNick(mallory, "DeployBot"), errUsernameInUse)
+	assert.ErrorIs(t, s.cmdNick(mallory, systemUser), errUsernameInUse)
+	require.NoError(t, s.cmdNick(mallory, "carol"))
+	assert.Equal(t, "carol", mallory.name)
+	assert.NoError(t, s.cmdNick(mallory, "mallory"), "users can take their earlier name back")
+}
+
+func TestCommands_RateLimit(t *testing.T) {
+	s := newTestServer(t)
+	s.config.Store(&Config{RateLimit: 2})
+	alice, toAlice := connect(t, s, "alice")
+
+	s.relay(alice, &packets.Message{Payload: "hi"})
+	<-toAlice
+	s.handleCommand(alice, &packets.Command{Name: "me", Args: "waves"})
+	assert.Equal(t, "* alice waves", (<-toAlice).(*packets.Message).Payload)
+	s.handleCommand(alice, &packets.Command{Name: "me", Args: "waves again"})
+	assert.Equal(t, "Not sent: you can only send 2 messages per minute", (<-toAlice).(*packets.Message).Payload, "actions count with messages")
+
+	s.handleCommand(alice, &packets.Command{Name: "topic"})
+	assert.Equal(t, "No topic is set for #general", (<-toAlice).(*packets.Message).Payload, "showing the topic is not limited")
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Config holds the settings the server is started with. It can be read from
+// a JSON file whose keys are the names of the matching command line flags.
+type Config struct {
+	Port int `json:"port"`
+	// MOTD is sent to every user right after a successful handshake.
+	MOTD string `json:"motd"`
+	// Topic is the topic of the room, set on start and whenever it changes
+	// in the configuration file. Moderators can change it in between.
+	Topic string `json:"topic"`
+	// RateLimit is the number of messages a user can send per minute, which
+	// is unlimited when it is 0.
+	RateLimit int `json:"rate-limit"`
+	// Moderators are the usernames allowed to run privileged commands such as
+	// setting a room topic.
+	Moderators []string `json:"moderators"`
+	// HistoryFile is where messages and read positions are persisted. The
+	// history is kept in memory only when it is empty.
+	HistoryFile string `json:"history-file"`
+	// HistoryLimit is the number of messages kept in the history.
+	HistoryLimit int `json:"history-limit"`
+	// FileDir is where transferred files are stored for later download. Files
+	// are only relayed to the users accepting them live when it is empty.
+	FileDir string `json:"file-dir"`
+	// MaxFileSize is the size limit of transferred files, in bytes.
+	MaxFileSize int64 `json:"max-file-size"`
+	// FileQuota is how many bytes the stored files can take in total, the
+	// oldest ones being deleted to make room for new ones.
+	FileQuota int64 `json:"file-quota"`
+	// UserFileQuota is how many bytes the files stored by a single user can
+	// take.
+	UserFileQuota int64 `json:"user-file-quota"`
+	// WebPort is the port of the WebSocket gateway letting browsers join the
+	// chat. The gateway is disabled when it is 0.
+	WebPort int `json:"web-port"`
+	// IRCPort is the port of the IRC gateway, disabled when it is 0.
+	IRCPort int `json:"irc-port"`
+	// APIPort is the port of the HTTP API, disabled when it is 0.
+	APIPort int `json:"api-port"`
+	// MetricsPort is the port of the Prometheus metrics endpoint, disabled
+	// when it is 0.
+	MetricsPort int `json:"metrics-port"`
+	// APITokens maps the bot accounts of the HTTP API to their secret
+	// token. They are only read from the configuration file.
+	APITokens map[string]string `json:"api-tokens"`
+	// Webhooks are the URLs chat events are posted to. They are only read
+	// from the configuration file.
+	Webhooks []Webhook `json:"webhooks"`
+	// AdminSocket is the path of the Unix socket of the admin console, which
+	// is disabled when it is empty.
+	AdminSocket string `json:"admin-socket"`
+	// Bans are the usernames and IP addresses refused by the server.
+	Bans []string `json:"bans"`
+	// Plugins are the plugins packets go through, in order. They are only
+	// read from the configuration file.
+	Plugins []PluginConfig `json:"plugins"`
+}
+
+// LoadConfig reads a configuration file into config, leaving the settings
+// the file does not mention untouched. The settings are checked by the server
+// using them.
+func LoadConfig(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// validate checks the settings, except for the plugins which are checked as
+// they are loaded.
+func (c *Config) validate() error {
+	if c.RateLimit < 0 {
+		return fmt.Errorf("invalid rate limit %d", c.RateLimit)
+	}
+
+	for name, token := range c.APITokens {
+		if name == "" || len(name) > maxUsernameLength || strings.ContainsFunc(name, unicode.IsSpace) || strings.EqualFold(name, systemUser) {
+			return fmt.Errorf("invalid API bot name %q", name)
+		}
+		if len(token) < minAPITokenLength {
+			return fmt.Errorf("the API token of %s must be at least %d characters long", name, minAPITokenLength)
+		}
+	}
+
+	for i := range c.Webhooks {
+		if err := c.Webhooks[i].validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// settings returns the live configuration, which must not be modified.
+func (s *Server) settings() *Config {
+	if config := s.config.Load(); config != nil {
+		return config
+	}
+	return &Config{}
+}
+
+func (s *Server) isModerator(username string) bool {
+	return slices.Contains(s.settings().Moderators, username)
+}
+
+// banned tells whether a user is banned, by name or address, by the
+// configuration or by an administrator. The caller must hold s.mu.
+func (s *Server) banned(username string, addr net.Addr) bool {
+	ip := ""
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		ip = host
+	}
+
+	// Bans made from the console are keyed in lowercase
+	if _, ok := s.bans[strings.ToLower(username)]; ok {
+		return true
+	}
+	if _, ok := s.bans[strings.ToLower(ip)]; ok && ip != "" {
+		return true
+	}
+	return slices.ContainsFunc(s.settings().Bans, func(ban string) bool { return strings.EqualFold(ban, username) || ban == ip })
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxUnfinishedOffers is how many transfers a user can have in progress
+	// at once.
+	maxUnfinishedOffers = 3
+	// transferIdleTimeout is how long an unfinished transfer can go without
+	// progress before it is aborted.
+	transferIdleTimeout   = 5 * time.Minute
+	transferSweepInterval = time.Minute
+	// defaultFileQuota and defaultUserFileQuota bound the size of the stored
+	// files, overall and per user, when the configuration does not say
+	// otherwise.
+	defaultFileQuota     = 1 << 30
+	defaultUserFileQuota = 256 << 20
+	// uploadPattern names the files being uploaded, which are removed on
+	// start as their upload cannot resume.
+	uploadPattern = "transfer-*"
+)
+
+// The files of the file directory, stored under the ID of their transfer: the
+// content and the offer describing it.
+func storedFilePath(dir string, id uint64) string {
+	return filepath.Join(dir, strconv.FormatUint(id, 10)+".file")
+}
+
+func storedOfferPath(dir string, id uint64) string {
+	return filepath.Join(dir, strconv.FormatUint(id, 10)+".json")
+}
+
+// loadStoredFiles rebuilds the stored files from the file directory,
+// returning them as complete transfers along with the highest ID in use.
+// Leftovers of interrupted uploads are removed.
+func loadStoredFiles(dir string) (map[uint64]*transfer, uint64, error) {
+	transfers := make(map[uint64]*transfer)
+	if dir == "" {
+		return transfers, 0, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return transfers, 0, nil
+	} else if err != nil {
+		return nil, 0, fmt.Errorf("failed to read file directory: %w", err)
+	}
+
+	var lastID uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if matched, _ := filepath.Match(uploadPattern, name); matched {
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+
+		base, ok := strings.CutSuffix(name, ".json")
+		id, err := strconv.ParseUint(base, 10, 64)
+		if !ok || err != nil {
+			continue
+		}
+		lastID = max(lastID, id)
+
+		t, err := loadStoredFile(dir, id)
+		if err != nil {
+			transferLogger.Warn("Dropping stored file", "id", id, "err", err)
+			os.Remove(storedFilePath(dir, id))
+			os.Remove(storedOfferPath(dir, id))
+			continue
+		}
+		transfers[id] = t
+	}
+
+	// Content without an offer was not completely stored
+	for _, entry := range entries {
+		base, ok := strings.CutSuffix(entry.Name(), ".file")
+		if id, err := strconv.ParseUint(base, 10, 64); ok && err == nil && transfers[id] == nil {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	return transfers, lastID, nil
+}
+
+func loadStoredFile(dir string, id uint64) (*transfer, error) {
+	data, err := os.ReadFile(storedOfferPath(dir, id))
+	if err != nil {
+		return nil, err
+	}
+
+	t := &transfer{path: storedFilePath(dir, id), started: true, complete: true}
+	if err := json.Unmarshal(data, &t.offer); err != nil {
+		return nil, err
+	}
+	if t.offer.ID != id {
+		return nil, fmt.Errorf("the offer is for #%d", t.offer.ID)
+	}
+
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(info.Size()) != t.offer.Size {
+		return nil, fmt.Errorf("%d bytes are stored instead of %d", info.Size(), t.offer.Size)
+	}
+	t.received = t.offer.Size
+	return t, nil
+}
+
+// storeFile moves a completely uploaded file to its place in the file
+// directory and records its offer. The caller must hold t.mu.
+func storeFile(t *transfer) error {
+	dir := filepath.Dir(t.path)
+	path := storedFilePath(dir, t.offer.ID)
+	if err := os.Rename(t.path, path); err != nil {
+		return err
+	}
+	t.path = path
+
+	data, err := json.Marshal(&t.offer)
+	if err != nil {
+		return err
+	}
+
+	// The offer is written last, a file is only stored once it exists
+	tmp := storedOfferPath(dir, t.offer.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, storedOfferPath(dir, t.offer.ID))
+}
+
+// removeStoredFile deletes a stored file along with its offer.
+func removeStoredFile(t *transfer) {
+	os.Remove(t.path)
+	os.Remove(storedOfferPath(filepath.Dir(t.path), t.offer.ID))
+}
+
+// checkLimits tells whether a user can offer another file of the given size,
+// picking the oldest stored files to delete to make room for it. The caller
+// must hold s.mu.
+func (s *Server) checkLimits(username string, size uint64, stored bool) ([]*transfer, error) {
+	var unfinished int
+	var total, own uint64
+	var complete []*transfer
+	for _, t := range s.transfers {
+		t.mu.Lock()
+		if t.offer.From == username && !t.complete {
+			unfinished++
+		}
+		if t.stored() {
+			total += t.offer.Size
+			if t.offer.From == username {
+				own += t.offer.Size
+			}
+			if t.complete {
+				complete = append(complete, t)
+			}
+		}
+		t.mu.Unlock()
+	}
+
+	if unfinished >= maxUnfinishedOffers {
+		return nil, fmt.Errorf("you can only send %d files at once", maxUnfinishedOffers)
+	}
+	if !stored {
+		return nil, nil
+	}
+
+	quota, userQuota := s.settings().FileQuota, s.settings().UserFileQuota
+	if quota <= 0 {
+		quota = defaultFileQuota
+	}
+	if userQuota <= 0 {
+		userQuota = defaultUserFileQuota
+	}
+	if size > uint64(userQuota) || size > uint64(quota) {
+		return nil, errors.New("the file is larger than the storage quota")
+	}
+
+	// The user makes room among their own files first, then everyone's
+	slices.SortFunc(complete, func(a, b *transfer) int { return cmp.Compare(a.offer.ID, b.offer.ID) })
+	var evicted []*transfer
+	for _, t := range complete {
+		if own+size > uint64(userQuota) && t.offer.From == username {
+			evicted = append(evicted, t)
+			own -= t.offer.Size
+			total -= t.offer.Size
+		}
+	}
+	for _, t := range complete {
+		if total+size <= uint64(quota) {
+			break
+		}
+		if !slices.Contains(evicted, t) {
+			evicted = append(evicted, t)
+			total -= t.offer.Size
+		}
+	}
+
+	if own+size > uint64(userQuota) || total+size > uint64(quota) {
+		return nil, errors.New("the server is out of space for files, try again later")
+	}
+	return evicted, nil
+}
+
+// sweepTransfers regularly aborts the transfers that stopped making progress.
+func (s *Server) sweepTransfers() {
+	ticker := time.NewTicker(transferSweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.expireTransfers(time.Now().Add(-transferIdleTimeout))
+	}
+}
+
+// expireTransfers aborts the unfinished transfers that made no progress since
+// deadline.
+func (s *Server) expireTransfers(deadline time.Time) {
+	s.mu.Lock()
+	var expired []*transfer
+	for _, t := range s.transfers {
+		t.mu.Lock()
+		if !t.complete && t.updated.Before(deadline) {
+			expired = append(expired, t)
+		}
+		t.mu.Unlock()
+	}
+	s.mu.Unlock()
+
+	for _, t := range expired {
+		s.abortTransfer(t, "the upload expired")
+	}
+}
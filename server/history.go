@@ -0,0 +1,476 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/root-man/chat/packets"
+)
+
+const (
+	// defaultHistoryLimit is the number of messages kept when the
+	// configuration does not say otherwise.
+	defaultHistoryLimit = 1000
+	// replayLength is the number of recent messages sent to a user on login.
+	replayLength = 50
+	// maxMentions is the number of mentions remembered per user.
+	maxMentions = 100
+	// historyFlushInterval is how often changes to the history are written
+	// to its file.
+	historyFlushInterval = time.Second
+)
+
+// history stores relayed messages along with their reactions, the read
+// position of every user and who was mentioned where, optionally persisting
+// them to a JSON file. The messages are indexed for search.
+type history struct {
+	path  string
+	limit int
+
+	mu    sync.Mutex
+	state historyState
+	// words is rebuilt from the messages on load rather than persisted.
+	words searchIndex
+	// dirty is set when the state changed since it was last written, which
+	// happens every historyFlushInterval rather than on every change.
+	dirty bool
+
+	// writing serializes the writes of the file.
+	writing sync.Mutex
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+type historyState struct {
+	NextID        uint64             `json:"next_id"`
+	Messages      []*packets.Message `json:"messages"`
+	ReadPositions map[string]uint64  `json:"read_positions"`
+	// Reactions maps message IDs to the users who reacted with each emoji.
+	Reactions map[uint64]map[string][]string `json:"reactions"`
+	// Mentions maps usernames to the IDs of the messages mentioning them.
+	Mentions map[string][]uint64 `json:"mentions"`
+}
+
+// openHistory loads the history stored at path, starting an empty one if the
+// file does not exist yet. An empty path keeps the history in memory only.
+func openHistory(path string, limit int) (*history, error) {
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+
+	h := &history{path: path, limit: limit, state: historyState{
+		NextID:        1,
+		ReadPositions: make(map[string]uint64),
+		Reactions:     make(map[uint64]map[string][]string),
+		Mentions:      make(map[string][]uint64),
+	}, words: make(searchIndex)}
+	if path == "" {
+		return h, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		h.startFlushing()
+		return h, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &h.state); err != nil {
+		return nil, fmt.Errorf("failed to parse history %s: %w", path, err)
+	}
+
+	if h.state.ReadPositions == nil {
+		h.state.ReadPositions = make(map[string]uint64)
+	}
+
+	if h.state.Reactions == nil {
+		h.state.Reactions = make(map[uint64]map[string][]string)
+	}
+
+	if h.state.Mentions == nil {
+		h.state.Mentions = make(map[string][]uint64)
+	}
+
+	for _, m := range h.state.Messages {
+		h.words.add(m)
+	}
+
+	// Older versions kept the mentions of dropped messages
+	h.mu.Lock()
+	for username, ids := range h.state.Mentions {
+		kept := slices.DeleteFunc(ids, func(id uint64) bool {
+			_, err := h.index(id)
+			return err != nil
+		})
+		if len(kept) == len(ids) {
+			continue
+		}
+
+		h.dirty = true
+		if len(kept) == 0 {
+			delete(h.state.Mentions, username)
+		} else {
+			h.state.Mentions[username] = kept
+		}
+	}
+	h.mu.Unlock()
+
+	h.startFlushing()
+	return h, nil
+}
+
+// append assigns the message its ID and stores it, recording the users it
+// mentions.
+func (h *history) append(msg *packets.Message) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	msg.ID = h.state.NextID
+	h.state.NextID++
+
+	stored := *msg
+	h.state.Messages = append(h.state.Messages, &stored)
+	h.words.add(&stored)
+	h.addMentions(&stored)
+	if overflow := len(h.state.Messages) - h.limit; overflow > 0 {
+		for _, m := range h.state.Messages[:overflow] {
+			delete(h.state.Reactions, m.ID)
+			h.words.remove(m)
+			h.forgetMentions(m)
+		}
+		h.state.Messages = h.state.Messages[overflow:]
+	}
+
+	h.dirty = true
+	return nil
+}
+
+// addMentions records the users a message mentions. The caller must hold h.mu.
+func (h *history) addMentions(m *packets.Message) {
+	for _, username := range packets.Mentions(m.Payload) {
+		ids := h.state.Mentions[username]
+		i, found := slices.BinarySearch(ids, m.ID)
+		if found {
+			continue
+		}
+		ids = slices.Insert(ids, i, m.ID)
+		h.state.Mentions[username] = ids[max(len(ids)-maxMentions, 0):]
+	}
+}
+
+// forgetMentions drops the mentions of a message, along with the users it
+// leaves with none so that mentions of made-up names do not pile up. The
+// caller must hold h.mu.
+func (h *history) forgetMentions(m *packets.Message) {
+	for _, username := range packets.Mentions(m.Payload) {
+		ids := slices.DeleteFunc(h.state.Mentions[username], func(id uint64) bool { return id == m.ID })
+		if len(ids) == 0 {
+			delete(h.state.Mentions, username)
+		} else {
+			h.state.Mentions[username] = ids
+		}
+	}
+}
+
+// get returns a copy of the message with the given ID.
+func (h *history) get(id uint64) (*packets.Message, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i, err := h.index(id)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := *h.state.Messages[i]
+	return &msg, nil
+}
+
+// modify applies fn to the stored message with the given ID and saves the
+// change unless fn fails.
+func (h *history) modify(id uint64, fn func(m *packets.Message) error) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i, err := h.index(id)
+	if err != nil {
+		return err
+	}
+
+	updated := *h.state.Messages[i]
+	if err := fn(&updated); err != nil {
+		return err
+	}
+
+	h.words.remove(h.state.Messages[i])
+	h.words.add(&updated)
+	h.forgetMentions(h.state.Messages[i])
+	h.addMentions(&updated)
+	h.state.Messages[i] = &updated
+	h.dirty = true
+	return nil
+}
+
+// remove deletes the message with the given ID if check allows it.
+func (h *history) remove(id uint64, check func(m *packets.Message) error) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i, err := h.index(id)
+	if err != nil {
+		return err
+	}
+
+	if err := check(h.state.Messages[i]); err != nil {
+		return err
+	}
+
+	delete(h.state.Reactions, id)
+	h.words.remove(h.state.Messages[i])
+	h.forgetMentions(h.state.Messages[i])
+	h.state.Messages = slices.Delete(h.state.Messages, i, i+1)
+	h.dirty = true
+	return nil
+}
+
+// react adds or removes the reaction of a user on a message. It reports
+// whether the reactions changed.
+func (h *history) react(id uint64, username string, emoji string, add bool) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, err := h.index(id); err != nil {
+		return false, err
+	}
+
+	users := h.state.Reactions[id][emoji]
+	reacted := slices.Contains(users, username)
+	if add == reacted {
+		return false, nil
+	}
+
+	if add {
+		users = append(users, username)
+	} else {
+		users = slices.DeleteFunc(users, func(u string) bool { return u == username })
+	}
+
+	if h.state.Reactions[id] == nil {
+		h.state.Reactions[id] = make(map[string][]string)
+	}
+
+	if len(users) == 0 {
+		delete(h.state.Reactions[id], emoji)
+		if len(h.state.Reactions[id]) == 0 {
+			delete(h.state.Reactions, id)
+		}
+	} else {
+		h.state.Reactions[id][emoji] = users
+	}
+
+	h.dirty = true
+	return true, nil
+}
+
+// reactions returns the reactions on the given messages, one packet per user
+// and emoji.
+func (h *history) reactions(messages []*packets.Message) []*packets.Reaction {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var reactions []*packets.Reaction
+	for _, m := range messages {
+		byEmoji := h.state.Reactions[m.ID]
+		for _, emoji := range slices.Sorted(maps.Keys(byEmoji)) {
+			for _, u := range byEmoji[emoji] {
+				reactions = append(reactions, &packets.Reaction{MessageID: m.ID, Username: u, Emoji: emoji, Add: true})
+			}
+		}
+	}
+
+	return reactions
+}
+
+// index returns the position of the message with the given ID. The caller
+// must hold h.mu.
+func (h *history) index(id uint64) (int, error) {
+	i, found := slices.BinarySearchFunc(h.state.Messages, id, func(m *packets.Message, id uint64) int {
+		return cmp.Compare(m.ID, id)
+	})
+	if !found {
+		return 0, fmt.Errorf("message #%d does not exist", id)
+	}
+
+	return i, nil
+}
+
+// mentions returns copies of the stored messages that mentioned username,
+// oldest first, along with how many of them are newer than the user's read
+// position.
+func (h *history) mentions(username string) ([]packets.Message, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var messages []packets.Message
+	unread := 0
+	for _, id := range h.state.Mentions[username] {
+		i, err := h.index(id)
+		if err != nil {
+			// The message was deleted or dropped from the history
+			continue
+		}
+
+		messages = append(messages, *h.state.Messages[i])
+		if id > h.state.ReadPositions[username] {
+			unread++
+		}
+	}
+
+	return messages, unread
+}
+
+// recent returns copies of the last n messages, oldest first.
+func (h *history) recent(n int) []*packets.Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	start := max(len(h.state.Messages)-n, 0)
+	messages := make([]*packets.Message, 0, len(h.state.Messages)-start)
+	for _, m := range h.state.Messages[start:] {
+		msg := *m
+		messages = append(messages, &msg)
+	}
+
+	return messages
+}
+
+// markRead moves the read position of username forward to messageID. It
+// reports whether the position changed.
+func (h *history) markRead(username string, messageID uint64) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if messageID >= h.state.NextID || messageID <= h.state.ReadPositions[username] {
+		return false, nil
+	}
+
+	h.state.ReadPositions[username] = messageID
+	h.dirty = true
+	return true, nil
+}
+
+// readPositions returns the read position of every user.
+func (h *history) readPositions() map[string]uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return maps.Clone(h.state.ReadPositions)
+}
+
+// rename carries the read position of a user over to their new name.
+func (h *history) rename(from string, to string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	position, ok := h.state.ReadPositions[from]
+	if !ok || position <= h.state.ReadPositions[to] {
+		return nil
+	}
+
+	h.state.ReadPositions[to] = position
+	h.dirty = true
+	return nil
+}
+
+func (h *history) startFlushing() {
+	h.stop, h.stopped = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(h.stopped)
+
+		ticker := time.NewTicker(historyFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-h.stop:
+				return
+			case <-ticker.C:
+				if err := h.flush(); err != nil {
+					logger.Error("Failed to save history", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+// close stops flushing the history in the background and writes the last
+// changes.
+func (h *history) close() error {
+	if h.stop == nil {
+		return nil
+	}
+
+	close(h.stop)
+	<-h.stopped
+	return h.flush()
+}
+
+// flush writes the history to its file if it changed, replacing the previous
+// version atomically.
+func (h *history) flush() error {
+	if h.path == "" {
+		return nil
+	}
+
+	h.writing.Lock()
+	defer h.writing.Unlock()
+
+	h.mu.Lock()
+	if !h.dirty {
+		h.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(&h.state)
+	h.dirty = false
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := h.write(data); err != nil {
+		// Try again next time
+		h.mu.Lock()
+		h.dirty = true
+		h.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (h *history) write(data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(h.path), filepath.Base(h.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save history: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save history: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save history: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), h.path)
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/root-man/chat/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+
+	h, err := openHistory(path, 2)
+	require.NoError(t, err)
+
+	for _, payload := range []string{"one", "two", "three"} {
+		msg := &packets.Message{From: "alice", Payload: payload, Timestamp: time.Unix(256, 0)}
+		require.NoError(t, h.append(msg))
+	}
+
+	changed, err := h.markRead("bob", 2)
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	changed, err = h.markRead("bob", 1)
+	require.NoError(t, err)
+	assert.False(t, changed, "read positions only move forward")
+
+	changed, err = h.markRead("bob", 4)
+	require.NoError(t, err)
+	assert.False(t, changed, "messages that do not exist cannot be read")
+
+	_, err = os.Stat(path)
+	assert.ErrorIs(t, err, fs.ErrNotExist, "changes are written in the background")
+	require.NoError(t, h.close())
+
+	reopened, err := openHistory(path, 2)
+	require.NoError(t, err)
+	t.Cleanup(func() { reopened.close() })
+
+	recent := reopened.recent(10)
+	require.Len(t, recent, 2)
+	assert.Equal(t, uint64(2), recent[0].ID)
+	assert.Equal(t, "three", recent[1].Payload)
+	assert.Equal(t, map[string]uint64{"bob": 2}, reopened.readPositions())
+
+	msg := &packets.Message{From: "bob", Payload: "four"}
+	require.NoError(t, reopened.append(msg))
+	assert.Equal(t, uint64(4), msg.ID)
+
+	changed, err = reopened.markRead("bob", 2)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Eventually(t, func() bool {
+		data, err := os.ReadFile(path)
+		return err == nil && strings.Contains(string(data), `"four"`)
+	}, 3*historyFlushInterval, historyFlushInterval/10, "changes are flushed periodically")
+}
+
+func TestHistory_Mentions(t *testing.T) {
+	h, err := openHistory("", 0)
+	require.NoError(t, err)
+
+	for _, payload := range []string{"@bob hi", "nothing here", "@bob, @carol: lunch?"} {
+		require.NoError(t, h.append(&packets.Message{From: "alice", Payload: payload}))
+	}
+
+	_, err = h.markRead("bob", 1)
+	require.NoError(t, err)
+	require.NoError(t, h.remove(1, func(*packets.Message) error { return nil }))
+
+	messages, unread := h.mentions("bob")
+	require.Len(t, messages, 1)
+	assert.Equal(t, uint64(3), messages[0].ID)
+	assert.Equal(t, 1, unread)
+
+	messages, unread = h.mentions("carol")
+	assert.Len(t, messages, 1)
+	assert.Equal(t, 1, unread)
+
+	require.NoError(t, h.modify(3, func(m *packets.Message) error {
+		m.Payload = "@dave: lunch?"
+		return nil
+	}))
+	messages, _ = h.mentions("dave")
+	assert.Len(t, messages, 1, "edits mention users too")
+	assert.NotContains(t, h.state.Mentions, "carol", "users left without mentions are forgotten")
+}
+
+func TestHistory_MentionsPruned(t *testing.T) {
+	h, err := openHistory("", 2)
+	require.NoError(t, err)
+
+	require.NoError(t, h.append(&packets.Message{From: "mallory", Payload: "@x1 @x2 @x3"}))
+	for _, payload := range []string{"@bob hi", "bye"} {
+		require.NoError(t, h.append(&packets.Message{From: "alice", Payload: payload}))
+	}
+
+	assert.Equal(t, map[string][]uint64{"bob": {2}}, h.state.Mentions, "mentions are dropped along with their messages")
+}
+
+func TestHistory_StaleMentionsPrunedOnLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+	stale := `{"next_id": 3, "messages": [{"ID": 2, "From": "alice", "Payload": "@bob hi"}], "mentions": {"bob": [1, 2], "x1": [1]}}`
+	require.NoError(t, os.WriteFile(path, []byte(stale), 0o600))
+
+	h, err := openHistory(path, 0)
+	require.NoError(t, err)
+	require.NoError(t, h.close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var saved historyState
+	require.NoError(t, json.Unmarshal(data, &saved))
+	assert.Equal(t, map[string][]uint64{"bob": {2}}, saved.Mentions, "the pruned mentions are saved")
+}
+
+func TestHistory_Search(t *testing.T) {
+	h, err := openHistory("", 0)
+	require.NoError(t, err)
+
+	messages := []*packets.Message{
+		{From: "alice", Payload: "The deploy link: https://ci.example.com/42", Timestamp: time.Unix(100, 0)},
+		{From: "bob", Payload: "Deployment done", Timestamp: time.Unix(200, 0)},
+		{From: "alice", Payload: "lunch?", Timestamp: time.Unix(300, 0)},
+		{From: "alice", Payload: "Redeploy the link please", Timestamp: time.Unix(400, 0)},
+	}
+	for _, m := range messages {
+		require.NoError(t, h.append(m))
+	}
+
+	payloads := func(messages []packets.Message) []string {
+		var payloads []string
+		for _, m := range messages {
+			payloads = append(payloads, m.Payload)
+		}
+		return payloads
+	}
+
+	results, total := h.search(&packets.SearchRequest{Query: "DEPLOY"})
+	assert.Equal(t, 2, total, "words match by prefix, case insensitively")
+	assert.Equal(t, []string{"Deployment done", "The deploy link: https://ci.example.com/42"}, payloads(results))
+
+	results, _ = h.search(&packets.SearchRequest{Query: "link example"})
+	assert.Equal(t, []string{"The deploy link: https://ci.example.com/42"}, payloads(results))
+
+	results, _ = h.search(&packets.SearchRequest{From: "alice", Since: time.Unix(200, 0)})
+	assert.Equal(t, []string{"Redeploy the link please", "lunch?"}, payloads(results))
+
+	results, total = h.search(&packets.SearchRequest{From: "alice", Offset: 1, Limit: 1})
+	assert.Equal(t, 3, total)
+	assert.Equal(t, []string{"lunch?"}, payloads(results))
+
+	require.NoError(t, h.modify(3, func(m *packets.Message) error {
+		m.Payload = "dinner?"
+		return nil
+	}))
+	require.NoError(t, h.remove(1, func(*packets.Message) error { return nil }))
+
+	_, total = h.search(&packets.SearchRequest{Query: "lunch"})
+	assert.Zero(t, total)
+	_, total = h.search(&packets.SearchRequest{Query: "example"})
+	assert.Zero(t, total)
+	results, _ = h.search(&packets.SearchRequest{Query: "dinner"})
+	assert.Equal(t, []string{"dinner?"}, payloads(results))
+}
@@ -0,0 +1,519 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/root-man/chat/packets"
+)
+
+const (
+	// ircServerName prefixes the replies of the server.
+	ircServerName = "chat"
+	// ircChannel is the single channel of the gateway, mapped to the default
+	// room.
+	ircChannel = "#" + defaultRoom
+	// maxIRCLine is the longest line accepted from clients, tags included.
+	maxIRCLine = 8192
+	// maxIRCText is the longest text sent in a single PRIVMSG, keeping
+	// lines within the 512 bytes of RFC 1459 once prefixed.
+	maxIRCText = 400
+)
+
+// ircTransport lets IRC clients such as irssi or weechat join the chat. It
+// speaks enough of RFC 1459 and 2812 to chat in ircChannel, translating
+// commands to packets and the other way around.
+type ircTransport struct {
+	s     *Server
+	conn  net.Conn
+	lines *bufio.Scanner
+
+	// mu guards the fields below.
+	mu   sync.Mutex
+	nick string
+	// joined is cleared while the user parted from the channel.
+	joined bool
+	// welcomed is set once the welcome packets were sent. Until then, the
+	// lines for the channel are held back in replay to follow the JOIN.
+	welcomed bool
+	replay   []string
+}
+
+// serveIRC accepts the connections of IRC clients.
+func (s *Server) serveIRC() {
+	for {
+		conn, err := s.irc.Accept()
+		if err != nil {
+			ircLogger.Error("IRC gateway stopped", "err", err)
+			return
+		}
+
+		go s.handleIRC(conn)
+	}
+}
+
+func (s *Server) handleIRC(conn net.Conn) {
+	ircLogger.Info("Got incoming connection, waiting for registration", "remote", conn.RemoteAddr().String())
+	t := &ircTransport{s: s, conn: conn, lines: bufio.NewScanner(conn), nick: "*"}
+	t.lines.Buffer(make([]byte, 512), maxIRCLine)
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	sess, err := t.register()
+	if err != nil {
+		ircLogger.Warn("Registration failed", "remote", conn.RemoteAddr().String(), "err", err)
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	s.announce(sess)
+	s.handleConnection(sess)
+}
+
+// register waits for the NICK and USER commands of the client and joins the
+// chat under that nick, as well as the channel.
+func (t *ircTransport) register() (*session, error) {
+	var nick string
+	var user bool
+	for t.lines.Scan() {
+		command, params := parseIRC(t.lines.Text())
+		switch command {
+		case "NICK":
+			if len(params) == 0 {
+				t.numeric("431", "No nickname given")
+				continue
+			}
+			nick = params[0]
+		case "USER":
+			user = true
+		case "PING":
+			t.pong(params)
+		case "CAP":
+			// Capabilities are not supported, but clients asking wait for an answer
+			if len(params) > 0 && params[0] == "LS" {
+				t.write(ircLine(ircServerName, "CAP", "*", "LS", ""))
+			}
+		case "PASS":
+		case "QUIT":
+			return nil, io.EOF
+		default:
+			t.numeric("451", "You have not registered")
+		}
+
+		if nick == "" || !user {
+			continue
+		}
+
+		t.mu.Lock()
+		t.nick = nick
+		t.mu.Unlock()
+
+		sess, err := t.s.join(t, &packets.Handshake{Username: nick, Version: packets.ProtocolVersion}, t.welcome)
+		if err != nil {
+			t.mu.Lock()
+			t.nick = "*"
+			t.mu.Unlock()
+
+			if errors.Is(err, errUsernameInUse) {
+				t.numeric("433", nick, "Nickname is already in use")
+			} else {
+				t.numeric("432", nick, "Erroneous nickname: "+err.Error())
+			}
+			nick = ""
+			continue
+		}
+
+		t.join()
+		return sess, nil
+	}
+
+	if err := t.lines.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// welcome answers the registration, standing for the handshake response.
+func (t *ircTransport) welcome(*packets.HandshakeResponse) error {
+	t.numeric("001", "Welcome to the chat "+t.nick)
+	t.numeric("002", "Your host is "+ircServerName)
+	t.numeric("003", "This server speaks protocol version "+fmt.Sprint(packets.ProtocolVersion))
+	t.numeric("004", ircServerName, fmt.Sprint(packets.ProtocolVersion), "i", "nt")
+	if t.s.settings().MOTD == "" {
+		return t.numeric("422", "MOTD File is missing")
+	}
+	return nil
+}
+
+// join makes the user join the channel, sending the held back replay the
+// first time.
+func (t *ircTransport) join() {
+	t.mu.Lock()
+	if t.joined {
+		t.mu.Unlock()
+		return
+	}
+
+	t.joined = true
+	lines := []string{ircLine(ircPrefix(t.nick), "JOIN", ircChannel)}
+	if !t.welcomed {
+		// The replay holds the topic, which comes right after the JOIN
+		lines = append(lines, t.replay...)
+		t.welcomed = true
+		t.replay = nil
+	} else {
+		t.s.mu.Lock()
+		topic := t.s.rooms[defaultRoom].topicPacket()
+		t.s.mu.Unlock()
+		lines = append(lines, t.topicReply(topic)...)
+	}
+	t.mu.Unlock()
+
+	t.write(lines...)
+	t.names()
+}
+
+func (t *ircTransport) names() {
+	t.mu.Lock()
+	nick := t.nick
+	t.mu.Unlock()
+
+	t.write(
+		ircLine(ircServerName, "353", nick, "=", ircChannel, strings.Join(t.s.onlineUsers(), " ")),
+		ircLine(ircServerName, "366", nick, ircChannel, "End of /NAMES list"),
+	)
+}
+
+func (t *ircTransport) readPacket() (packets.Packet, error) {
+	for t.lines.Scan() {
+		command, params := parseIRC(t.lines.Text())
+		p, err := t.handle(command, params)
+		if p != nil || err != nil {
+			return p, err
+		}
+	}
+
+	if err := t.lines.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// handle answers a command of a registered client, returning the packet it
+// stands for if any.
+func (t *ircTransport) handle(command string, params []string) (packets.Packet, error) {
+	switch command {
+	case "PING":
+		t.pong(params)
+	case "PONG", "NOTICE", "CAP", "USER", "PASS", "":
+	case "NICK":
+		if len(params) == 0 {
+			t.numeric("431", "No nickname given")
+			return nil, nil
+		}
+		return &packets.Command{Name: "nick", Args: params[0]}, nil
+	case "PRIVMSG":
+		if len(params) < 2 || params[1] == "" {
+			t.numeric("412", "No text to send")
+			return nil, nil
+		}
+		return t.privmsg(params[0], params[1]), nil
+	case "JOIN":
+		if len(params) == 0 {
+			t.numeric("461", "JOIN", "Not enough parameters")
+			return nil, nil
+		}
+		for _, channel := range strings.Split(params[0], ",") {
+			if channel == "0" {
+				t.part()
+			} else if strings.EqualFold(channel, ircChannel) {
+				t.join()
+			} else {
+				t.numeric("403", channel, "No such channel")
+			}
+		}
+	case "PART":
+		if len(params) == 0 {
+			t.numeric("461", "PART", "Not enough parameters")
+			return nil, nil
+		}
+		for _, channel := range strings.Split(params[0], ",") {
+			if strings.EqualFold(channel, ircChannel) {
+				t.part()
+			} else {
+				t.numeric("403", channel, "No such channel")
+			}
+		}
+	case "NAMES":
+		t.names()
+	case "TOPIC":
+		if len(params) < 2 {
+			t.s.mu.Lock()
+			topic := t.s.rooms[defaultRoom].topicPacket()
+			t.s.mu.Unlock()
+
+			t.mu.Lock()
+			lines := t.topicReply(topic)
+			t.mu.Unlock()
+			t.write(lines...)
+			return nil, nil
+		}
+		return &packets.Command{Name: "topic", Args: params[1]}, nil
+	case "MODE":
+		if len(params) > 0 && strings.EqualFold(params[0], ircChannel) {
+			t.numeric("324", ircChannel, "+nt")
+		}
+	case "WHO":
+		t.numeric("315", strings.Join(params, " "), "End of /WHO list")
+	case "QUIT":
+		t.write("ERROR :Closing link")
+		return nil, io.EOF
+	default:
+		t.numeric("421", command, "Unknown command")
+	}
+
+	return nil, nil
+}
+
+// privmsg turns a PRIVMSG to the channel into a message, or /me for a CTCP
+// ACTION.
+func (t *ircTransport) privmsg(target string, text string) packets.Packet {
+	if !strings.EqualFold(target, ircChannel) {
+		if strings.HasPrefix(target, "#") {
+			t.numeric("403", target, "No such channel")
+		} else {
+			t.numeric("401", target, "Private messages are not supported")
+		}
+		return nil
+	}
+
+	t.mu.Lock()
+	joined := t.joined
+	t.mu.Unlock()
+	if !joined {
+		t.numeric("404", target, "Cannot send to channel")
+		return nil
+	}
+
+	if action, ok := strings.CutPrefix(text, "\x01ACTION "); ok {
+		return &packets.Command{Name: "me", Args: strings.TrimSuffix(action, "\x01")}
+	} else if strings.HasPrefix(text, "\x01") {
+		// Other CTCP requests such as VERSION are not answered
+		return nil
+	}
+
+	return &packets.Message{Payload: text, Timestamp: time.Now()}
+}
+
+func (t *ircTransport) part() {
+	t.mu.Lock()
+	joined := t.joined
+	t.joined = false
+	nick := t.nick
+	t.mu.Unlock()
+
+	if joined {
+		t.write(ircLine(ircPrefix(nick), "PART", ircChannel))
+	}
+}
+
+func (t *ircTransport) pong(params []string) {
+	token := ircServerName
+	if len(params) > 0 {
+		token = params[0]
+	}
+	t.write(ircLine(ircServerName, "PONG", ircServerName, token))
+}
+
+func (t *ircTransport) writePackets(ps ...packets.Packet) error {
+	t.mu.Lock()
+	var lines []string
+	for _, p := range ps {
+		// The MOTD belongs to the registration, not to the channel
+		if _, motd := p.(*packets.Motd); motd || t.welcomed {
+			lines = append(lines, t.translate(p)...)
+		} else {
+			t.replay = append(t.replay, t.translate(p)...)
+		}
+	}
+	t.mu.Unlock()
+
+	return t.write(lines...)
+}
+
+// translate returns the IRC lines standing for p, except for the packets
+// IRC has no use for. Before the user is welcomed, it translates the replay
+// of the history. The caller must hold t.mu.
+func (t *ircTransport) translate(p packets.Packet) []string {
+	switch p := p.(type) {
+	case *packets.Message:
+		if p.From == systemUser {
+			return ircText(ircServerName, "NOTICE", t.nick, p.Payload)
+		}
+		if t.welcomed && (!t.joined || p.From == t.nick) {
+			// IRC clients show the messages they send themselves
+			return nil
+		}
+
+		text := p.Payload
+		if !t.welcomed {
+			text = fmt.Sprintf("[%s] %s", p.Time().Local().Format(time.TimeOnly), text)
+		}
+		return ircText(ircPrefix(p.From), "PRIVMSG", ircChannel, text)
+	case *packets.MessageEdit:
+		if !t.joined {
+			return nil
+		}
+		return ircText(ircPrefix(p.EditedBy), "NOTICE", ircChannel, "edited a message: "+p.Payload)
+	case *packets.Motd:
+		lines := []string{ircLine(ircServerName, "375", t.nick, "- "+ircServerName+" Message of the day -")}
+		for _, line := range ircLineBreak.Split(p.Text, -1) {
+			lines = append(lines, ircLine(ircServerName, "372", t.nick, "- "+line))
+		}
+		return append(lines, ircLine(ircServerName, "376", t.nick, "End of /MOTD command"))
+	case *packets.Topic:
+		if !t.welcomed {
+			return t.topicReply(p)
+		}
+		return []string{ircLine(ircPrefix(p.SetBy), "TOPIC", ircChannel, p.Text)}
+	case *packets.Presence:
+		switch {
+		case p.Username == t.nick:
+			return nil
+		case p.Status:
+			return []string{ircLine(ircPrefix(p.Username), "JOIN", ircChannel)}
+		default:
+			return []string{ircLine(ircPrefix(p.Username), "QUIT", "Left the chat")}
+		}
+	case *packets.Rename:
+		if p.From == t.nick {
+			t.nick = p.To
+		}
+		return []string{ircLine(ircPrefix(p.From), "NICK", p.To)}
+	case *packets.MentionList:
+		var lines []string
+		for _, m := range p.Messages {
+			lines = append(lines, ircText(ircServerName, "NOTICE", t.nick, m.From+": "+m.Payload)...)
+		}
+		return lines
+	}
+
+	return nil
+}
+
+// topicReply returns the numeric replies describing the topic.
+func (t *ircTransport) topicReply(topic *packets.Topic) []string {
+	if topic.Text == "" {
+		return []string{ircLine(ircServerName, "331", t.nick, ircChannel, "No topic is set")}
+	}
+
+	return []string{
+		ircLine(ircServerName, "332", t.nick, ircChannel, topic.Text),
+		ircLine(ircServerName, "333", t.nick, ircChannel, topic.SetBy, fmt.Sprint(topic.SetAt.Unix())),
+	}
+}
+
+// numeric sends a numeric reply to the user.
+func (t *ircTransport) numeric(code string, params ...string) error {
+	t.mu.Lock()
+	nick := t.nick
+	t.mu.Unlock()
+
+	return t.write(ircLine(ircServerName, code, append([]string{nick}, params...)...))
+}
+
+func (t *ircTransport) write(lines ...string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	_, err := t.conn.Write([]byte(strings.Join(lines, "\r\n") + "\r\n"))
+	return err
+}
+
+func (t *ircTransport) close() error {
+	return t.conn.Close()
+}
+
+func (t *ircTransport) remoteAddr() net.Addr {
+	return t.conn.RemoteAddr()
+}
+
+// parseIRC splits a line into its command and parameters, dropping the tags
+// and the prefix.
+func parseIRC(line string) (string, []string) {
+	line = strings.TrimRight(line, "\r")
+	if strings.HasPrefix(line, "@") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	if strings.HasPrefix(line, ":") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+
+	line, trailing, hasTrailing := strings.Cut(line, " :")
+	if strings.HasPrefix(line, ":") {
+		line, trailing, hasTrailing = "", line[1:], true
+	}
+
+	params := strings.Fields(line)
+	if hasTrailing {
+		params = append(params, trailing)
+	}
+	if len(params) == 0 {
+		return "", nil
+	}
+
+	return strings.ToUpper(params[0]), params[1:]
+}
+
+// ircLine formats a line, the last parameter being able to hold spaces.
+func ircLine(prefix string, command string, params ...string) string {
+	line := ":" + prefix + " " + command
+	for i, param := range params {
+		// Line breaks would let the text of users inject lines
+		param = ircUnsafe.Replace(param)
+		if i == len(params)-1 {
+			line += " :" + param
+		} else {
+			line += " " + param
+		}
+	}
+	return line
+}
+
+// ircUnsafe replaces the characters that cannot appear within an IRC line.
+var ircUnsafe = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ", "\x00", "")
+
+// ircLineBreak matches the line breaks of texts, as IRC clients take a lone
+// CR for one too.
+var ircLineBreak = regexp.MustCompile(`\r\n|\r|\n`)
+
+// ircText returns the lines sending text, which IRC wants on a single line
+// of limited length.
+func ircText(prefix string, command string, target string, text string) []string {
+	var lines []string
+	for _, line := range ircLineBreak.Split(text, -1) {
+		for len(line) > maxIRCText {
+			cut := maxIRCText
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			lines = append(lines, ircLine(prefix, command, target, line[:cut]))
+			line = line[cut:]
+		}
+		lines = append(lines, ircLine(prefix, command, target, line))
+	}
+	return lines
+}
+
+func ircPrefix(nick string) string {
+	return nick + "!" + nick + "@" + ircServerName
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/root-man/chat/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ircClient is a scripted IRC client.
+type ircClient struct {
+	t     *testing.T
+	conn  net.Conn
+	lines chan string
+}
+
+func dialIRC(t *testing.T, s *Server) *ircClient {
+	server, client := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	go s.handleIRC(server)
+
+	c := &ircClient{t: t, conn: client, lines: make(chan string, 100)}
+	go func() {
+		scanner := bufio.NewScanner(client)
+		for scanner.Scan() {
+			c.lines <- scanner.Text()
+		}
+		close(c.lines)
+	}()
+	return c
+}
+
+func (c *ircClient) send(format string, args ...any) {
+	_, err := fmt.Fprintf(c.conn, format+"\r\n", args...)
+	require.NoError(c.t, err)
+}
+
+// expect skips lines until one contains want, and returns it.
+func (c *ircClient) expect(want string) string {
+	c.t.Helper()
+	for {
+		select {
+		case line, ok := <-c.lines:
+			require.True(c.t, ok, "connection closed while expecting %q", want)
+			if strings.Contains(line, want) {
+				return line
+			}
+		case <-time.After(time.Second):
+			c.t.Fatalf("timed out expecting %q", want)
+		}
+	}
+}
+
+func TestIRCGateway(t *testing.T) {
+	s := newTestServer(t)
+	s.rooms[defaultRoom].topic = "Welcome"
+	alice, toAlice := connect(t, s, "alice")
+	s.relay(alice, &packets.Message{Payload: "before bob came"})
+	<-toAlice
+
+	bob := dialIRC(t, s)
+	bob.send("CAP LS 302")
+	bob.expect("CAP * LS")
+	bob.send("NICK alice")
+	bob.send("USER bob 0 * :Bob")
+	bob.expect("433 * alice :Nickname is already in use")
+	bob.send("NICK bob")
+	bob.expect(":chat 001 bob :Welcome")
+	bob.expect(":bob!bob@chat JOIN :#general")
+	bob.expect(":chat 332 bob #general :Welcome")
+	bob.expect("PRIVMSG #general :[")
+	bob.expect(":chat 353 bob = #general :alice bob")
+
+	assert.Equal(t, "User bob has joined the chat!", (<-toAlice).(*packets.Message).Payload)
+	assert.Equal(t, &packets.Presence{Username: "bob", Status: true}, <-toAlice)
+
+	bob.send("PRIVMSG #general :hello from irssi")
+	msg := (<-toAlice).(*packets.Message)
+	assert.Equal(t, "bob", msg.From)
+	assert.Equal(t, "hello from irssi", msg.Payload)
+
+	s.relay(alice, &packets.Message{Payload: "hi bob\nhow are you?"})
+	<-toAlice
+	bob.expect(":alice!alice@chat PRIVMSG #general :hi bob")
+	bob.expect(":alice!alice@chat PRIVMSG #general :how are you?")
+
+	bob.send("PRIVMSG #general :\x01ACTION waves\x01")
+	assert.Equal(t, "* bob waves", (<-toAlice).(*packets.Message).Payload)
+
+	bob.send("JOIN #random")
+	bob.expect("403 bob #random :No such channel")
+	bob.send("PING :token")
+	bob.expect("PONG chat :token")
+
+	bob.send("NICK robert")
+	assert.Equal(t, &packets.Rename{From: "bob", To: "robert"}, <-toAlice)
+	bob.expect(":bob!bob@chat NICK :robert")
+
+	bob.send("PART #general")
+	bob.expect(":robert!robert@chat PART :#general")
+	s.relay(alice, &packets.Message{Payload: "not for robert"})
+	<-toAlice
+	bob.send("NAMES #general")
+	bob.expect("353 robert = #general :alice robert")
+
+	bob.send("QUIT :bye")
+	bob.expect("ERROR :Closing link")
+	assert.Equal(t, "User robert has left the chat.", (<-toAlice).(*packets.Message).Payload)
+}
+
+func TestParseIRC(t *testing.T) {
+	tests := []struct {
+		line    string
+		command string
+		params  []string
+	}{
+		{line: "NICK bob", command: "NICK", params: []string{"bob"}},
+		{line: "privmsg #general :hello there :)", command: "PRIVMSG", params: []string{"#general", "hello there :)"}},
+		{line: "@time=x :bob!b@host PRIVMSG #general :hi\r", command: "PRIVMSG", params: []string{"#general", "hi"}},
+		{line: "USER bob 0 * :Bob Smith", command: "USER", params: []string{"bob", "0", "*", "Bob Smith"}},
+		{line: "", command: ""},
+	}
+
+	for _, tt := range tests {
+		command, params := parseIRC(tt.line)
+		assert.Equal(t, tt.command, command, tt.line)
+		assert.Equal(t, tt.params, params, tt.line)
+	}
+}
+
+func TestIRCLineBreaks(t *testing.T) {
+	tr := &ircTransport{nick: "bob", welcomed: true, joined: true}
+
+	lines := tr.translate(&packets.Message{From: "mallory", Payload: "hi\r:evil!e@chat KICK #general bob\nthere\r\nagain"})
+	assert.Equal(t, []string{
+		":mallory!mallory@chat PRIVMSG #general :hi",
+		":mallory!mallory@chat PRIVMSG #general ::evil!e@chat KICK #general bob",
+		":mallory!mallory@chat PRIVMSG #general :there",
+		":mallory!mallory@chat PRIVMSG #general :again",
+	}, lines)
+
+	lines = tr.translate(&packets.Topic{Room: defaultRoom, Text: "news\r\nQUIT\rnow\x00", SetBy: "alice"})
+	assert.Equal(t, []string{":alice!alice@chat TOPIC #general :news QUIT now"}, lines)
+	for _, line := range lines {
+		assert.NotContains(t, line, "\r")
+		assert.NotContains(t, line, "\n")
+	}
+}
@@ -0,0 +1,16 @@
+package server
+
+import "github.com/root-man/chat/logging"
+
+// The loggers of the components of the server, whose levels can be set
+// separately.
+var (
+	logger         = logging.For("server")
+	transferLogger = logging.For("transfer")
+	wsLogger       = logging.For("websocket")
+	ircLogger      = logging.For("irc")
+	apiLogger      = logging.For("api")
+	webhookLogger  = logging.For("webhooks")
+	pluginLogger   = logging.For("plugins")
+	metricsLogger  = logging.For("metrics")
+)
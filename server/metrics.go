@@ -0,0 +1,176 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/root-man/chat/packets"
+)
+
+// The reasons handshakes fail for, as reported by the metrics.
+const (
+	handshakeMalformed = "malformed"
+	handshakeVersion   = "version"
+	handshakeUsername  = "username"
+	handshakePlugin    = "plugin"
+	handshakeBanned    = "banned"
+	handshakeIO        = "io"
+)
+
+// metrics are the Prometheus metrics of a server. A nil *metrics, as in
+// tests, records nothing.
+type metrics struct {
+	registry          *prometheus.Registry
+	received          *prometheus.CounterVec
+	sent              *prometheus.CounterVec
+	bytesReceived     *prometheus.CounterVec
+	bytesSent         *prometheus.CounterVec
+	handshakeFailures *prometheus.CounterVec
+	multicastDuration prometheus.Histogram
+}
+
+func newMetrics(s *Server) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chat_packets_received_total",
+			Help: "Packets received from users, by type.",
+		}, []string{"type"}),
+		sent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chat_packets_sent_total",
+			Help: "Packets sent to users, by type.",
+		}, []string{"type"}),
+		bytesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chat_bytes_received_total",
+			Help: "Bytes received, by listener.",
+		}, []string{"listener"}),
+		bytesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chat_bytes_sent_total",
+			Help: "Bytes sent, by listener.",
+		}, []string{"listener"}),
+		handshakeFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chat_handshake_failures_total",
+			Help: "Handshakes that failed, by reason.",
+		}, []string{"reason"}),
+		multicastDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "chat_multicast_duration_seconds",
+			Help:    "Time taken to send a packet to all its recipients.",
+			Buckets: prometheus.ExponentialBuckets(50e-6, 4, 10),
+		}),
+	}
+
+	m.registry.MustRegister(m.received, m.sent, m.bytesReceived, m.bytesSent, m.handshakeFailures, m.multicastDuration,
+		sessionCollector{s}, collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return m
+}
+
+func (m *metrics) packetsReceived(p packets.Packet) {
+	if m != nil {
+		m.received.WithLabelValues(p.Type().String()).Inc()
+	}
+}
+
+func (m *metrics) packetsSent(ps ...packets.Packet) {
+	if m == nil {
+		return
+	}
+	for _, p := range ps {
+		m.sent.WithLabelValues(p.Type().String()).Inc()
+	}
+}
+
+func (m *metrics) handshakeFailed(reason string) {
+	if m != nil {
+		m.handshakeFailures.WithLabelValues(reason).Inc()
+	}
+}
+
+func (m *metrics) multicastDone(start time.Time) {
+	if m != nil {
+		m.multicastDuration.Observe(time.Since(start).Seconds())
+	}
+}
+
+// listen wraps a listener so that the bytes of its connections are counted
+// under its name.
+func (m *metrics) listen(l net.Listener, name string) net.Listener {
+	if m == nil || l == nil {
+		return l
+	}
+	return &countingListener{Listener: l, received: m.bytesReceived.WithLabelValues(name), sent: m.bytesSent.WithLabelValues(name)}
+}
+
+// serveMetrics exposes the metrics to Prometheus.
+func (s *Server) serveMetrics() {
+	server := &http.Server{Handler: s.metricsHandler(), ReadHeaderTimeout: 10 * time.Second}
+	if err := server.Serve(s.metricsListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		metricsLogger.Error("Metrics endpoint stopped", "err", err)
+	}
+}
+
+func (s *Server) metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{}))
+	return mux
+}
+
+// sessionCollector reports the connected users and how many packets are
+// waiting to be written to each of them.
+type sessionCollector struct {
+	s *Server
+}
+
+var (
+	clientsDesc    = prometheus.NewDesc("chat_clients_connected", "Users currently connected.", nil, nil)
+	queueDepthDesc = prometheus.NewDesc("chat_send_queue_depth", "Writes waiting on the connection of a user.", []string{"user"}, nil)
+)
+
+func (c sessionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- clientsDesc
+	ch <- queueDepthDesc
+}
+
+func (c sessionCollector) Collect(ch chan<- prometheus.Metric) {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(clientsDesc, prometheus.GaugeValue, float64(len(c.s.conns)))
+	for name, sess := range c.s.conns {
+		ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(sess.pending.Load()), name)
+	}
+}
+
+type countingListener struct {
+	net.Listener
+	received, sent prometheus.Counter
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: conn, received: l.received, sent: l.sent}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	received, sent prometheus.Counter
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.received.Add(float64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.sent.Add(float64(n))
+	return n, err
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/root-man/chat/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	s := newTestServer(t)
+	s.metrics = newMetrics(s)
+	alice, toAlice := connect(t, s, "alice")
+	alice.metrics = s.metrics
+
+	s.relay(alice, &packets.Message{Payload: "hello"})
+	<-toAlice
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		client.Write(packets.Frame(&packets.Handshake{Username: "alice", Version: packets.ProtocolVersion}))
+		io.Copy(io.Discard, client)
+	}()
+	_, err := s.handshake(server)
+	assert.ErrorIs(t, err, errUsernameInUse)
+
+	endpoint := httptest.NewServer(s.metricsHandler())
+	defer endpoint.Close()
+	resp, err := http.Get(endpoint.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	for _, want := range []string{
+		"chat_clients_connected 1",
+		`chat_send_queue_depth{user="alice"} 0`,
+		`chat_packets_sent_total{type="message"} 1`,
+		`chat_handshake_failures_total{reason="username"} 1`,
+		"chat_multicast_duration_seconds_count 1",
+	} {
+		assert.Contains(t, string(body), want)
+	}
+}
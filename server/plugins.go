@@ -0,0 +1,149 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/root-man/chat/packets"
+)
+
+// Plugin takes part in the handling of packets through hooks, each of them
+// optional. Plugins are chained in the order of the configuration.
+type Plugin struct {
+	// OnHandshake is called when a user connects, before they join. An error
+	// turns them away.
+	OnHandshake func(h *packets.Handshake) error
+	// BeforeRelay is called for every packet a user sends, before the server
+	// handles it. It may modify or replace the packet of the event, or drop
+	// it by returning the reason, which the user is told.
+	BeforeRelay func(e *Event) error
+	// AfterRelay is called once a message was delivered and stored.
+	AfterRelay func(e *Event)
+	// OnDisconnect is called when a user leaves.
+	OnDisconnect func(username string)
+}
+
+// PluginConfig enables a plugin, with options specific to it.
+type PluginConfig struct {
+	Name    string          `json:"name"`
+	Options json.RawMessage `json:"options"`
+}
+
+// Event is a packet going through the plugins.
+type Event struct {
+	// User is who sent the packet.
+	User   string
+	Packet packets.Packet
+	s      *Server
+}
+
+// Emit sends an additional packet to every online user. It may be called
+// after the hook returned, e.g. once some slow work is done.
+func (e *Event) Emit(p packets.Packet) {
+	e.s.multicast(p, e.s.onlineUsers())
+}
+
+// plugins is the registry of plugins, by name. Each entry creates a plugin
+// from its options.
+var plugins = map[string]func(options json.RawMessage) (*Plugin, error){
+	"profanity-filter": newProfanityFilter,
+	"url-unfurl":       newURLUnfurler,
+}
+
+// RegisterPlugin makes a plugin available to the configuration, e.g. from the
+// init function of the package providing it.
+func RegisterPlugin(name string, create func(options json.RawMessage) (*Plugin, error)) {
+	plugins[name] = create
+}
+
+// loadPlugins creates the plugins of the configuration.
+func loadPlugins(configs []PluginConfig) ([]*Plugin, error) {
+	var loaded []*Plugin
+	for _, config := range configs {
+		create, ok := plugins[config.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown plugin %q, expected one of %v", config.Name, slices.Sorted(maps.Keys(plugins)))
+		}
+
+		p, err := create(config.Options)
+		if err != nil {
+			return nil, fmt.Errorf("invalid options for plugin %s: %w", config.Name, err)
+		}
+		loaded = append(loaded, p)
+	}
+	return loaded, nil
+}
+
+// activePlugins returns the plugins, which must not be modified.
+func (s *Server) activePlugins() []*Plugin {
+	s.hooksMu.RLock()
+	defer s.hooksMu.RUnlock()
+	return s.plugins
+}
+
+func (s *Server) pluginsOnHandshake(h *packets.Handshake) error {
+	for _, p := range s.activePlugins() {
+		if p.OnHandshake == nil {
+			continue
+		}
+		if err := p.OnHandshake(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pluginsBeforeRelay passes a packet through the plugins, returning the
+// packet to handle or nil if it was dropped.
+func (s *Server) pluginsBeforeRelay(sess *session, p packets.Packet) packets.Packet {
+	p, err := s.pluginsFilter(sess.name, p)
+	if err != nil {
+		s.notify(sess.name, fmt.Sprintf("Not sent: %s", err))
+		return nil
+	}
+	return p
+}
+
+// pluginsFilter passes a packet sent by a user through the plugins, returning
+// the packet to handle or why it was dropped.
+func (s *Server) pluginsFilter(username string, p packets.Packet) (packets.Packet, error) {
+	e := &Event{User: username, Packet: p, s: s}
+	for _, plugin := range s.activePlugins() {
+		if plugin.BeforeRelay == nil {
+			continue
+		}
+		if err := plugin.BeforeRelay(e); err != nil {
+			pluginLogger.Info("Dropped packet", "user", username, "reason", err, "packet", p)
+			return nil, err
+		}
+	}
+	return e.Packet, nil
+}
+
+func (s *Server) pluginsAfterRelay(msg *packets.Message) {
+	e := &Event{User: msg.From, Packet: msg, s: s}
+	for _, p := range s.activePlugins() {
+		if p.AfterRelay != nil {
+			p.AfterRelay(e)
+		}
+	}
+}
+
+func (s *Server) pluginsOnDisconnect(username string) {
+	for _, p := range s.activePlugins() {
+		if p.OnDisconnect != nil {
+			p.OnDisconnect(username)
+		}
+	}
+}
+
+// decodeOptions reads the options of a plugin into v, leaving the defaults in
+// place when there are none.
+func decodeOptions(options json.RawMessage, v any) error {
+	if len(options) == 0 {
+		return nil
+	}
+	return json.Unmarshal(options, v)
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/root-man/chat/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlugins(t *testing.T) {
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, "<html><head><title>\n  Release notes &amp; more\n</title></head></html>")
+	}))
+	defer page.Close()
+
+	loaded, err := loadPlugins([]PluginConfig{
+		{Name: "profanity-filter", Options: json.RawMessage(`{"words": ["darn"]}`)},
+		{Name: "profanity-filter", Options: json.RawMessage(`{"words": ["heck"], "action": "drop"}`)},
+		{Name: "url-unfurl", Options: json.RawMessage(`{"allow-private": true}`)},
+	})
+	require.NoError(t, err)
+
+	s := newTestServer(t)
+	s.plugins = loaded
+	alice, toAlice := connect(t, s, "alice")
+
+	p := s.pluginsBeforeRelay(alice, &packets.Message{Payload: "Darn it, the darning needle"})
+	assert.Equal(t, "**** it, the darning needle", p.(*packets.Message).Payload)
+
+	assert.Nil(t, s.pluginsBeforeRelay(alice, &packets.MessageEdit{ID: 1, Payload: "what the heck"}))
+	assert.Equal(t, "Not sent: your message contains a blocked word", (<-toAlice).(*packets.Message).Payload)
+
+	p = s.pluginsBeforeRelay(alice, &packets.Command{Name: "me", Args: "says darn"})
+	assert.Equal(t, "says ****", p.(*packets.Command).Args, "commands relaying text are filtered")
+	assert.Nil(t, s.pluginsBeforeRelay(alice, &packets.Command{Name: "topic", Args: "heck yes"}))
+	<-toAlice
+	p = s.pluginsBeforeRelay(alice, &packets.Command{Name: "nick", Args: "darn"})
+	assert.Equal(t, "darn", p.(*packets.Command).Args)
+
+	s.relay(alice, &packets.Message{Payload: "see " + page.URL + "/notes."})
+	<-toAlice
+	select {
+	case p := <-toAlice:
+		assert.Equal(t, "🔗 "+page.URL+"/notes: Release notes & more", p.(*packets.Message).Payload)
+	case <-time.After(time.Second):
+		t.Fatal("the link was not unfurled")
+	}
+
+	_, err = loadPlugins([]PluginConfig{{Name: "spellcheck"}})
+	assert.ErrorContains(t, err, `unknown plugin "spellcheck"`)
+	_, err = loadPlugins([]PluginConfig{{Name: "profanity-filter"}})
+	assert.ErrorContains(t, err, "no words to block")
+}
+
+func TestUnfurlRefusesPrivateAddresses(t *testing.T) {
+	assert.Error(t, refusePrivate("tcp", "127.0.0.1:80", nil))
+	assert.Error(t, refusePrivate("tcp", "[::ffff:10.0.0.1]:80", nil))
+	assert.NoError(t, refusePrivate("tcp", "93.184.216.34:443", nil))
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/root-man/chat/packets"
+)
+
+// profanityOptions configure the profanity filter plugin.
+type profanityOptions struct {
+	// Words are the blocked words, matched whole and regardless of case.
+	Words []string `json:"words"`
+	// Action is "mask" to replace the words with asterisks, or "drop" to
+	// refuse the messages containing them.
+	Action string `json:"action"`
+}
+
+// newProfanityFilter creates a plugin that masks or drops the blocked words in
+// messages, edits and the commands relaying text such as /me.
+func newProfanityFilter(options json.RawMessage) (*Plugin, error) {
+	opts := profanityOptions{Action: "mask"}
+	if err := decodeOptions(options, &opts); err != nil {
+		return nil, err
+	}
+	if len(opts.Words) == 0 {
+		return nil, errors.New("no words to block")
+	}
+	if opts.Action != "mask" && opts.Action != "drop" {
+		return nil, fmt.Errorf("unknown action %q, expected mask or drop", opts.Action)
+	}
+
+	quoted := make([]string, len(opts.Words))
+	for i, word := range opts.Words {
+		quoted[i] = regexp.QuoteMeta(word)
+	}
+	blocked := regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)
+
+	filter := func(text *string) error {
+		if !blocked.MatchString(*text) {
+			return nil
+		}
+		if opts.Action == "drop" {
+			return errors.New("your message contains a blocked word")
+		}
+		*text = blocked.ReplaceAllStringFunc(*text, func(word string) string {
+			return strings.Repeat("*", utf8.RuneCountInString(word))
+		})
+		return nil
+	}
+
+	return &Plugin{BeforeRelay: func(e *Event) error {
+		switch p := e.Packet.(type) {
+		case *packets.Message:
+			return filter(&p.Payload)
+		case *packets.MessageEdit:
+			return filter(&p.Payload)
+		case *packets.Command:
+			if commands[p.Name].relayed {
+				return filter(&p.Args)
+			}
+		}
+		return nil
+	}}, nil
+}
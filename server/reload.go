@@ -0,0 +1,97 @@
+package server
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// restartSettings are the settings, by name, which only take effect when the
+// server starts.
+var restartSettings = []string{"port", "web-port", "irc-port", "api-port", "metrics-port", "admin-socket", "history-file", "history-limit", "file-dir"}
+
+// SetConfigLoader sets how the configuration is read again on reload.
+func (s *Server) SetConfigLoader(load func() (Config, error)) {
+	s.loadConfig = load
+}
+
+// Reload reads the configuration again and swaps it for the live one without
+// dropping the connections. It is left untouched if the new one is invalid,
+// and the settings that need a restart keep their running value.
+func (s *Server) Reload() error {
+	if s.loadConfig == nil {
+		return errors.New("the server was not started with a configuration file")
+	}
+
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+
+	config, err := s.loadConfig()
+	if err != nil {
+		return err
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+	plugins, err := loadPlugins(config.Plugins)
+	if err != nil {
+		return err
+	}
+
+	var changed []string
+	fields, running := reflect.ValueOf(&config).Elem(), reflect.ValueOf(s.settings()).Elem()
+	for i := range fields.NumField() {
+		if reflect.DeepEqual(fields.Field(i).Interface(), running.Field(i).Interface()) {
+			continue
+		}
+
+		name, _, _ := strings.Cut(fields.Type().Field(i).Tag.Get("json"), ",")
+		if slices.Contains(restartSettings, name) {
+			logger.Warn("Setting changed, restart the server to apply it", "setting", name)
+			fields.Field(i).Set(running.Field(i))
+			continue
+		}
+		changed = append(changed, name)
+	}
+
+	s.config.Store(&config)
+	logger.Info("Reloaded configuration", "changed", changed)
+
+	if slices.Contains(changed, "plugins") {
+		s.hooksMu.Lock()
+		s.plugins = plugins
+		s.hooksMu.Unlock()
+	}
+	if slices.Contains(changed, "webhooks") {
+		s.replaceWebhooks(config.Webhooks)
+	}
+	if slices.Contains(changed, "bans") {
+		s.kickBanned("banned")
+	}
+	if slices.Contains(changed, "api-tokens") {
+		s.kickIf(func(name string, _ *session) bool { return s.isBot(name) }, "the name belongs to a bot")
+	}
+	if slices.Contains(changed, "topic") {
+		s.setTopic(config.Topic)
+	}
+	return nil
+}
+
+// setTopic changes the topic of the room to the one of the configuration.
+func (s *Server) setTopic(topic string) {
+	s.mu.Lock()
+	r, ok := s.rooms[defaultRoom]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	r.topic = topic
+	r.topicSetBy = systemUser
+	r.topicSetAt = time.Now()
+	p := r.topicPacket()
+	s.mu.Unlock()
+
+	s.multicast(p, s.onlineUsers())
+}
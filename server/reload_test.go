@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/root-man/chat/logging"
+	"github.com/root-man/chat/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReload(t *testing.T) {
+	logs := t.TempDir() + "/chat.log"
+	require.NoError(t, logging.Setup(logging.Config{File: logs}))
+	t.Cleanup(func() { logging.Setup(logging.Config{}) })
+
+	kept, removed := Webhook{URL: "http://127.0.0.1:1/kept"}, Webhook{URL: "http://127.0.0.1:1/removed"}
+	config := Config{Port: 4444, MOTD: "hello", Webhooks: []Webhook{kept, removed}}
+	s := newTestServer(t)
+	s.webhooks = startWebhooks(config.Webhooks)
+	s.config.Store(&config)
+	alice, toAlice := connect(t, s, "alice")
+	_, toBob := connect(t, s, "bob")
+	keptSender := s.webhooks[0]
+
+	next := config
+	s.SetConfigLoader(func() (Config, error) { return next, nil })
+
+	next = Config{Port: 5555, MOTD: "welcome", Topic: "release day", RateLimit: 1, Bans: []string{"bob"}, Webhooks: []Webhook{kept, {URL: "http://127.0.0.1:1/added"}}}
+	require.NoError(t, s.Reload())
+	assert.Equal(t, 4444, s.settings().Port, "the port needs a restart")
+	data, err := os.ReadFile(logs)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `msg="Setting changed, restart the server to apply it" component=server setting=port`)
+	assert.Equal(t, "welcome", s.settings().MOTD)
+	assert.Same(t, keptSender, s.webhooks[0], "unchanged webhooks keep their queue")
+	assert.Equal(t, "http://127.0.0.1:1/added", s.webhooks[1].URL)
+	assert.Len(t, s.webhooks, 2)
+
+	assert.Equal(t, "You were kicked by an administrator: banned", (<-toBob).(*packets.Message).Payload)
+	assert.Equal(t, "bob was kicked by an administrator: banned", (<-toAlice).(*packets.Message).Payload)
+	topic := (<-toAlice).(*packets.Topic)
+	assert.Equal(t, "release day", topic.Text)
+	assert.Equal(t, systemUser, topic.SetBy)
+
+	s.relay(alice, &packets.Message{Payload: "first"})
+	assert.Equal(t, "first", (<-toAlice).(*packets.Message).Payload)
+	s.relay(alice, &packets.Message{Payload: "second"})
+	assert.Equal(t, "Not sent: you can only send 1 messages per minute", (<-toAlice).(*packets.Message).Payload)
+
+	next.RateLimit = -1
+	assert.Error(t, s.Reload())
+	s.SetConfigLoader(func() (Config, error) { return Config{}, errors.New("unreadable") })
+	assert.EqualError(t, s.Reload(), "unreadable")
+	assert.Equal(t, 1, s.settings().RateLimit, "the live settings are kept when reloading fails")
+
+	s.SetConfigLoader(func() (Config, error) {
+		return Config{APITokens: map[string]string{"Alice": "alice-secret-token"}}, nil
+	})
+	require.NoError(t, s.Reload())
+	assert.Equal(t, "You were kicked by an administrator: the name belongs to a bot", (<-toAlice).(*packets.Message).Payload)
+}
+
+func TestReload_LoadsPluginsOnce(t *testing.T) {
+	var loads int
+	RegisterPlugin("counter", func(json.RawMessage) (*Plugin, error) {
+		loads++
+		return &Plugin{}, nil
+	})
+	t.Cleanup(func() { delete(plugins, "counter") })
+
+	s := newTestServer(t)
+	s.SetConfigLoader(func() (Config, error) { return Config{Plugins: []PluginConfig{{Name: "counter"}}}, nil })
+	require.NoError(t, s.Reload())
+	assert.Equal(t, 1, loads)
+	assert.Len(t, s.activePlugins(), 1)
+}
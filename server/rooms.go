@@ -0,0 +1,53 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/root-man/chat/packets"
+)
+
+// defaultRoom is the room every user joins on login.
+const defaultRoom = "general"
+
+type room struct {
+	name       string
+	topic      string
+	topicSetBy string
+	topicSetAt time.Time
+}
+
+func (r *room) topicPacket() *packets.Topic {
+	return &packets.Topic{Room: r.name, Text: r.topic, SetBy: r.topicSetBy, SetAt: r.topicSetAt}
+}
+
+func (s *Server) cmdTopic(sess *session, args string) error {
+	s.mu.Lock()
+	r := s.rooms[defaultRoom]
+
+	if args == "" {
+		topic := r.topicPacket()
+		s.mu.Unlock()
+
+		if topic.Text == "" {
+			s.notify(sess.name, fmt.Sprintf("No topic is set for #%s", topic.Room))
+		} else {
+			s.notify(sess.name, fmt.Sprintf("Topic for #%s: %s (set by %s)", topic.Room, topic.Text, topic.SetBy))
+		}
+		return nil
+	}
+
+	if !s.isModerator(sess.name) {
+		s.mu.Unlock()
+		return errors.New("only moderators can change the topic")
+	}
+
+	r.topic = args
+	r.topicSetBy = sess.name
+	r.topicSetAt = time.Now()
+	topic := r.topicPacket()
+	s.mu.Unlock()
+
+	return s.multicast(topic, s.onlineUsers())
+}
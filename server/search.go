@@ -0,0 +1,129 @@
+package server
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/root-man/chat/packets"
+)
+
+const (
+	// defaultSearchLimit is the page size used when a search request does
+	// not set one.
+	defaultSearchLimit = 20
+	// maxSearchLimit bounds the number of results sent in a single page.
+	maxSearchLimit = 100
+)
+
+// searchIndex is an inverted index from the words of the stored messages to
+// the IDs of the messages containing them.
+type searchIndex map[string]map[uint64]struct{}
+
+// tokenize splits text into lowercase words made of letters and digits,
+// without duplicates.
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	slices.Sort(words)
+	return slices.Compact(words)
+}
+
+func (idx searchIndex) add(m *packets.Message) {
+	for _, word := range tokenize(m.Payload) {
+		if idx[word] == nil {
+			idx[word] = make(map[uint64]struct{})
+		}
+		idx[word][m.ID] = struct{}{}
+	}
+}
+
+func (idx searchIndex) remove(m *packets.Message) {
+	for _, word := range tokenize(m.Payload) {
+		delete(idx[word], m.ID)
+		if len(idx[word]) == 0 {
+			delete(idx, word)
+		}
+	}
+}
+
+// lookup returns the IDs of the messages containing every word of the query,
+// each word matching the indexed words it is a prefix of.
+func (idx searchIndex) lookup(query []string) map[uint64]struct{} {
+	var found map[uint64]struct{}
+	for _, q := range query {
+		matches := make(map[uint64]struct{})
+		for word, ids := range idx {
+			if strings.HasPrefix(word, q) {
+				for id := range ids {
+					if _, ok := found[id]; ok || found == nil {
+						matches[id] = struct{}{}
+					}
+				}
+			}
+		}
+
+		found = matches
+		if len(found) == 0 {
+			break
+		}
+	}
+
+	return found
+}
+
+// search returns copies of the stored messages matching req, newest first,
+// along with the number of matches before pagination.
+func (h *history) search(req *packets.SearchRequest) ([]packets.Message, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var ids map[uint64]struct{}
+	if query := tokenize(req.Query); len(query) > 0 {
+		ids = h.words.lookup(query)
+	}
+
+	var matches []packets.Message
+	for i := len(h.state.Messages) - 1; i >= 0; i-- {
+		m := h.state.Messages[i]
+		if _, ok := ids[m.ID]; ids != nil && !ok {
+			continue
+		}
+
+		if req.From != "" && !strings.EqualFold(m.From, req.From) {
+			continue
+		}
+
+		if (!req.Since.IsZero() && m.Time().Before(req.Since)) || (!req.Until.IsZero() && !m.Time().Before(req.Until)) {
+			continue
+		}
+
+		matches = append(matches, *m)
+	}
+
+	limit := int(req.Limit)
+	if limit == 0 {
+		limit = defaultSearchLimit
+	}
+	limit = min(limit, maxSearchLimit)
+
+	start := min(int(req.Offset), len(matches))
+	end := min(start+limit, len(matches))
+	return matches[start:end], len(matches)
+}
+
+// search answers a search request of a user with a page of results.
+func (s *Server) search(sess *session, req *packets.SearchRequest) {
+	s.mu.Lock()
+	username := sess.name
+	s.mu.Unlock()
+
+	messages, total := s.history.search(req)
+	results := &packets.SearchResults{Query: req.Query, Messages: messages, Total: uint32(total), Offset: req.Offset}
+
+	logger.Debug("User searched", "user", username, "query", req.Query, "results", results.Total)
+	if err := s.send(results, username); err != nil {
+		logger.Warn("Failed to send search results", "user", username, "err", err)
+	}
+}
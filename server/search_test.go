@@ -0,0 +1,73 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/root-man/chat/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenize(t *testing.T) {
+	assert.Equal(t, []string{"42", "ci", "com", "deploy", "example", "https"}, tokenize("Deploy: https://ci.example.com/42, deploy!"))
+	assert.Empty(t, tokenize("?! --"))
+}
+
+func TestSearchIndex(t *testing.T) {
+	idx := make(searchIndex)
+	first := &packets.Message{ID: 1, Payload: "deploy the release"}
+	second := &packets.Message{ID: 2, Payload: "release notes"}
+	idx.add(first)
+	idx.add(second)
+
+	assert.Equal(t, map[uint64]struct{}{1: {}, 2: {}}, idx.lookup([]string{"rel"}), "words match by prefix")
+	assert.Equal(t, map[uint64]struct{}{1: {}}, idx.lookup([]string{"release", "dep"}), "every word must match")
+	assert.Empty(t, idx.lookup([]string{"notes", "deploy"}))
+	assert.Empty(t, idx.lookup([]string{"releases"}))
+
+	idx.remove(first)
+	assert.Equal(t, map[uint64]struct{}{2: {}}, idx.lookup([]string{"release"}))
+	assert.NotContains(t, idx, "deploy", "words of no message are dropped")
+}
+
+func TestSearch(t *testing.T) {
+	s := newTestServer(t)
+	for i := range 150 {
+		from := "alice"
+		if i%2 == 1 {
+			from = "bob"
+		}
+		require.NoError(t, s.history.append(&packets.Message{From: from, Payload: fmt.Sprintf("status %d", i), Timestamp: time.Unix(int64(i), 0)}))
+	}
+	alice, toAlice := connect(t, s, "alice")
+
+	search := func(req *packets.SearchRequest) *packets.SearchResults {
+		s.search(alice, req)
+		return (<-toAlice).(*packets.SearchResults)
+	}
+
+	results := search(&packets.SearchRequest{Query: "status"})
+	assert.Equal(t, uint32(150), results.Total)
+	assert.Len(t, results.Messages, defaultSearchLimit, "the page size defaults when unset")
+	assert.Equal(t, "status 149", results.Messages[0].Payload, "newest first")
+
+	results = search(&packets.SearchRequest{Query: "status", Limit: 1000})
+	assert.Len(t, results.Messages, maxSearchLimit, "the page size is capped")
+
+	results = search(&packets.SearchRequest{Query: "status", Offset: 140, Limit: 50})
+	assert.Len(t, results.Messages, 10)
+	assert.Equal(t, uint32(140), results.Offset)
+	results = search(&packets.SearchRequest{Query: "status", Offset: 1000})
+	assert.Empty(t, results.Messages, "pages past the end are empty")
+	assert.Equal(t, uint32(150), results.Total)
+
+	results = search(&packets.SearchRequest{From: "BOB", Since: time.Unix(10, 0), Until: time.Unix(15, 0)})
+	require.Len(t, results.Messages, 2, "until is exclusive")
+	assert.Equal(t, "status 13", results.Messages[0].Payload)
+	assert.Equal(t, "status 11", results.Messages[1].Payload)
+
+	results = search(&packets.SearchRequest{Query: "14"})
+	assert.Equal(t, uint32(11), results.Total, "14 and 140 to 149")
+}
@@ -4,175 +4,734 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"maps"
 	"net"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 
 	"github.com/root-man/chat/packets"
 )
 
+const (
+	maxUsernameLength = 32
+	maxEmojiLength    = 32
+	// handshakeTimeout bounds how long a client can take to join, from
+	// sending its handshake to receiving the welcome.
+	handshakeTimeout = 10 * time.Second
+)
+
+// errUsernameInUse is wrapped by checkUsername when a name is taken.
+var errUsernameInUse = errors.New("already in use")
+
+// supportedCapabilities are the protocol features the server grants to the
+// clients asking for them.
+const supportedCapabilities = packets.CapCompression
+
 type Server struct {
-	listener net.Listener
-	conns    map[string]net.Conn
-	mu       sync.Mutex
+	// config holds the live settings, replaced as a whole on reload.
+	config atomic.Pointer[Config]
+	// loadConfig reads the configuration again on reload.
+	loadConfig func() (Config, error)
+	reloading  sync.Mutex
+	listener   net.Listener
+	// web, irc and api are the listeners of the WebSocket and IRC gateways
+	// and of the HTTP API, if enabled.
+	web net.Listener
+	irc net.Listener
+	api net.Listener
+	// metricsListener serves the metrics, which are only collected when it
+	// is enabled.
+	metricsListener net.Listener
+	metrics         *metrics
+	conns           map[string]*session
+	rooms           map[string]*room
+	history         *history
+	// hooksMu guards plugins and webhooks, which are replaced on reload.
+	hooksMu sync.RWMutex
+	plugins []*Plugin
+	// webhooks deliver the chat events to the configured URLs.
+	webhooks []*webhookSender
+	// transfers are the file transfers in progress, and the stored files.
+	transfers      map[uint64]*transfer
+	nextTransferID uint64
+	// bans are the usernames and addresses banned by administrators, on top
+	// of those of the configuration, until the server stops, with the reason
+	// given. They are keyed in lowercase.
+	bans map[string]string
+	// admin is the Unix socket of the admin console, if enabled.
+	admin net.Listener
+	// apiRates rate limits the messages of API bots, by name.
+	apiRates map[string]*rateWindow
+	// closed is set once Close was called.
+	closed atomic.Bool
+	mu     sync.Mutex
+}
+
+// session is a connected user. Its name can change over the lifetime of the
+// connection, so it is only modified while holding Server.mu.
+type session struct {
+	name      string
+	conn      transport
+	connected time.Time
+	metrics   *metrics
+	// pending counts the writes waiting on the connection.
+	pending atomic.Int64
+	// welcomed is closed once the handshake response and the welcome packets
+	// were written, which the packets sent to the session wait for.
+	welcomed chan struct{}
+	// messages rate limits what the user sends.
+	messages rateWindow
+}
+
+// rateWindow counts messages over a minute for rate limiting.
+type rateWindow struct {
+	start time.Time
+	sent  int
+}
+
+// allow counts a message against a rate limit of limit messages per minute,
+// telling whether it can be sent. Messages are unlimited when limit is 0.
+func (w *rateWindow) allow(limit int) bool {
+	if limit == 0 {
+		return true
+	}
+
+	if now := time.Now(); now.Sub(w.start) >= time.Minute {
+		w.start, w.sent = now, 0
+	}
+	w.sent++
+	return w.sent <= limit
+}
+
+// write sends p to the session without logging it, for packets too frequent
+// to be worth it such as file chunks.
+func (sess *session) write(p packets.Packet) error {
+	sess.pending.Add(1)
+	defer sess.pending.Add(-1)
+
+	if sess.welcomed != nil {
+		<-sess.welcomed
+	}
+
+	sess.metrics.packetsSent(p)
+	return sess.conn.writePackets(p)
 }
 
-func New(portNumber int) (*Server, error) {
-	PORT := ":" + strconv.Itoa(portNumber)
+func New(config Config) (*Server, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	history, err := openHistory(config.HistoryFile, config.HistoryLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	plugins, err := loadPlugins(config.Plugins)
+	if err != nil {
+		return nil, err
+	}
+
+	transfers, lastTransferID, err := loadStoredFiles(config.FileDir)
+	if err != nil {
+		return nil, err
+	}
+
+	PORT := ":" + strconv.Itoa(config.Port)
 	l, err := net.Listen("tcp", PORT)
 	if err != nil {
-		log.Fatal(err)
 		return nil, err
 	}
 
-	log.Printf("Started chat server listening on port %d", portNumber)
+	logger.Info("Started chat server", "port", config.Port)
+
+	// The optional gateways are only listened on when a port is given
+	listeners := []net.Listener{l}
+	listen := func(port int, name string) (net.Listener, error) {
+		if port == 0 {
+			return nil, nil
+		}
+		listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+		logger.Info("Started "+name, "port", port)
+		return listener, nil
+	}
+
+	web, err := listen(config.WebPort, "WebSocket gateway")
+	if err != nil {
+		return nil, err
+	}
+	irc, err := listen(config.IRCPort, "IRC gateway")
+	if err != nil {
+		return nil, err
+	}
+	api, err := listen(config.APIPort, "HTTP API")
+	if err != nil {
+		return nil, err
+	}
+	metricsListener, err := listen(config.MetricsPort, "metrics endpoint")
+	if err != nil {
+		return nil, err
+	}
+	var admin net.Listener
+	if config.AdminSocket != "" {
+		if admin, err = listenAdmin(config.AdminSocket); err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		logger.Info("Started admin console", "socket", config.AdminSocket)
+	}
 
-	return &Server{listener: l, mu: sync.Mutex{}, conns: make(map[string]net.Conn)}, nil
+	rooms := map[string]*room{defaultRoom: {name: defaultRoom}}
+	if config.Topic != "" {
+		rooms[defaultRoom].topic = config.Topic
+		rooms[defaultRoom].topicSetBy = systemUser
+		rooms[defaultRoom].topicSetAt = time.Now()
+	}
+
+	s := &Server{listener: l, web: web, irc: irc, api: api, metricsListener: metricsListener, admin: admin, mu: sync.Mutex{}, conns: make(map[string]*session), rooms: rooms, history: history, plugins: plugins, webhooks: startWebhooks(config.Webhooks), transfers: transfers, nextTransferID: lastTransferID}
+	if metricsListener != nil {
+		s.metrics = newMetrics(s)
+		s.listener = s.metrics.listen(l, "tcp")
+		s.web = s.metrics.listen(web, "websocket")
+		s.irc = s.metrics.listen(irc, "irc")
+		s.api = s.metrics.listen(api, "api")
+	}
+	s.config.Store(&config)
+	return s, nil
 }
 
 func (s *Server) Run() error {
+	if s.web != nil {
+		go s.serveWeb()
+	}
+	if s.irc != nil {
+		go s.serveIRC()
+	}
+	if s.api != nil {
+		go s.serveAPI()
+	}
+	if s.metricsListener != nil {
+		go s.serveMetrics()
+	}
+	if s.admin != nil {
+		go s.serveAdmin()
+	}
+	go s.sweepTransfers()
+
 	for {
 		c, err := s.listener.Accept()
 		if err != nil {
-			fmt.Println(err)
+			if s.closed.Load() {
+				return nil
+			}
 			return err
 		}
 
-		log.Printf("Got incoming connection from %s, initiating handshake...", c.RemoteAddr())
-		username, err := s.handshake(c)
-		if err != nil {
-			log.Printf("Handshake with %s failed: %s", *username, err)
-			c.Close()
-			continue
+		go s.handleTCP(c)
+	}
+}
+
+func (s *Server) handleTCP(c net.Conn) {
+	logger.Info("Got incoming connection, initiating handshake", "remote", c.RemoteAddr().String())
+	c.SetDeadline(time.Now().Add(handshakeTimeout))
+	sess, err := s.handshake(c)
+	if err != nil {
+		logger.Warn("Handshake failed", "remote", c.RemoteAddr().String(), "err", err)
+		c.Close()
+		return
+	}
+	c.SetDeadline(time.Time{})
+
+	s.announce(sess)
+	s.handleConnection(sess)
+}
+
+// Close stops accepting connections, making Run return, and writes the
+// history to its file.
+func (s *Server) Close() error {
+	s.closed.Store(true)
+	// The history is saved first as the process may exit once Run returns
+	err := s.history.close()
+	for _, l := range []net.Listener{s.listener, s.web, s.irc, s.api, s.metricsListener, s.admin} {
+		if l != nil {
+			l.Close()
 		}
+	}
+	return err
+}
 
-		if len(s.conns) > 1 {
-			msg := &packets.Message{From: "CHAT", Payload: fmt.Sprintf("User %s has joined the chat!", *username), Timestamp: time.Now()}
-			// presence := &packets.Presence{Username: *username, Status: true}
+// announce lets the other users know that a user joined.
+func (s *Server) announce(sess *session) {
+	s.emit(&webhookEvent{Event: eventJoin, User: sess.name})
 
-			var to []string
+	to := s.onlineUsers()
+	if len(to) <= 1 {
+		return
+	}
 
-			for u := range maps.Keys(s.conns) {
-				to = append(to, u)
-			}
+	msg := &packets.Message{From: systemUser, Payload: fmt.Sprintf("User %s has joined the chat!", sess.name), Timestamp: time.Now()}
+	presence := &packets.Presence{Username: sess.name, Status: true}
 
-			go s.multicast(msg, to)
-			// go s.multicast(presence, to)
-		}
-		go s.handleConnection(*username)
+	go func() {
+		s.multicast(msg, to)
+		s.multicast(presence, to)
+	}()
+}
+
+func (s *Server) handshake(conn net.Conn) (*session, error) {
+	p, err := packets.ReadFrame(conn)
+	if err != nil {
+		s.metrics.handshakeFailed(handshakeMalformed)
+		return nil, err
 	}
+
+	handshake, ok := p.(*packets.Handshake)
+	if !ok {
+		s.metrics.handshakeFailed(handshakeMalformed)
+		return nil, fmt.Errorf("expected a handshake, got %s", p)
+	}
+
+	t := newTCPTransport(conn)
+	capabilities := handshake.Capabilities & supportedCapabilities
+	return s.join(t, handshake, func(response *packets.HandshakeResponse) error {
+		response.Capabilities = capabilities
+
+		// The response itself is never compressed, the client does not know
+		// yet whether it may be
+		if _, err := conn.Write(packets.Frame(response)); err != nil {
+			return err
+		}
+		t.compress = capabilities&packets.CapCompression != 0
+		return nil
+	})
 }
 
-func (s *Server) handshake(conn net.Conn) (*string, error) {
-	handshake := &packets.Handshake{}
-	if err := handshake.Receive(conn); err != nil {
+// join registers the session of a user who sent a handshake over t. respond
+// sends the handshake response in the way of the transport, the welcome
+// packets follow.
+func (s *Server) join(t transport, handshake *packets.Handshake, respond func(*packets.HandshakeResponse) error) (*session, error) {
+	if handshake.Version != packets.ProtocolVersion {
+		// Let the client know which version to upgrade to before hanging up
+		respond(&packets.HandshakeResponse{Version: packets.ProtocolVersion})
+		s.metrics.handshakeFailed(handshakeVersion)
+		return nil, fmt.Errorf("client speaks protocol version %d instead of %d", handshake.Version, packets.ProtocolVersion)
+	}
+
+	if err := s.pluginsOnHandshake(handshake); err != nil {
+		s.metrics.handshakeFailed(handshakePlugin)
 		return nil, err
 	}
 
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	if err := s.checkUsername(handshake.Username); err != nil {
+		s.mu.Unlock()
+		s.metrics.handshakeFailed(handshakeUsername)
+		return nil, err
+	}
 
-	if _, ok := s.conns[handshake.Username]; ok {
-		return nil, fmt.Errorf("username %s is already in use", handshake.Username)
+	if s.banned(handshake.Username, t.remoteAddr()) {
+		s.mu.Unlock()
+		s.metrics.handshakeFailed(handshakeBanned)
+		return nil, fmt.Errorf("%s is banned", handshake.Username)
 	}
 
-	onlineUsers := make([]string, len(s.conns))
+	onlineUsers := make([]string, 0, len(s.conns))
 	for i := range maps.Keys(s.conns) {
 		onlineUsers = append(onlineUsers, i)
 	}
 
-	handshakeResponse := packets.HandshakeResponse{OnlineUsers: onlineUsers}
-
-	_, err := conn.Write(handshakeResponse.Encode())
+	// The session is registered right away so that it misses nothing sent
+	// in the meantime, but only written to once welcomed
+	sess := &session{name: handshake.Username, conn: t, connected: time.Now(), metrics: s.metrics, welcomed: make(chan struct{})}
+	welcome := s.welcome(sess.name)
+	s.conns[sess.name] = sess
+	s.mu.Unlock()
+
+	err := respond(&packets.HandshakeResponse{OnlineUsers: onlineUsers, Version: packets.ProtocolVersion})
+	if err == nil {
+		sess.metrics.packetsSent(welcome...)
+		err = t.writePackets(welcome...)
+	}
+	close(sess.welcomed)
 	if err != nil {
+		s.mu.Lock()
+		delete(s.conns, sess.name)
+		s.mu.Unlock()
+		s.metrics.handshakeFailed(handshakeIO)
 		return nil, err
 	}
 
-	s.conns[handshake.Username] = conn
-	log.Printf("Handshake successful with username: %s", handshake.Username)
-	return &handshake.Username, nil
+	logger.Info("Handshake successful", "user", sess.name)
+	return sess, nil
+}
+
+// welcome returns the message of the day, the room topic and the recent
+// history for a user that just completed the handshake. The replay is framed by
+// the read positions: those of the other users come first and the user's own
+// position comes last, marking the end of the replay. It is all sent at once,
+// which makes it a single compressed frame for clients supporting compression.
+// The caller must hold s.mu.
+func (s *Server) welcome(username string) []packets.Packet {
+	var welcome []packets.Packet
+	if motd := s.settings().MOTD; motd != "" {
+		welcome = append(welcome, &packets.Motd{Text: motd})
+	}
+
+	if r := s.rooms[defaultRoom]; r.topic != "" {
+		welcome = append(welcome, r.topicPacket())
+	}
+
+	positions := s.history.readPositions()
+	for user, position := range positions {
+		if user != username {
+			welcome = append(welcome, &packets.ReadReceipt{Username: user, MessageID: position})
+		}
+	}
+
+	replay := s.history.recent(replayLength)
+	for _, msg := range replay {
+		welcome = append(welcome, msg)
+	}
+
+	for _, reaction := range s.history.reactions(replay) {
+		welcome = append(welcome, reaction)
+	}
+
+	welcome = append(welcome, &packets.ReadReceipt{Username: username, MessageID: positions[username]})
+
+	if _, unread := s.history.mentions(username); unread > 0 {
+		notice := &packets.Message{From: systemUser, Payload: fmt.Sprintf("You were mentioned in %d messages since your last visit, type /mentions to see them", unread), Timestamp: time.Now()}
+		welcome = append(welcome, notice)
+	}
+
+	return welcome
 }
 
-func (s *Server) handleConnection(username string) {
-	conn := s.conns[username]
-	defer conn.Close()
+// checkUsername reports whether name can be taken by a new or renamed
+// session. The caller must hold s.mu.
+func (s *Server) checkUsername(name string) error {
+	if name == "" || strings.ContainsFunc(name, unicode.IsSpace) {
+		return fmt.Errorf("username %q must be non-empty and contain no spaces", name)
+	}
+
+	if len(name) > maxUsernameLength {
+		return fmt.Errorf("username %s is longer than %d characters", name, maxUsernameLength)
+	}
+
+	if _, ok := s.conns[name]; ok || strings.EqualFold(name, systemUser) || s.isBot(name) {
+		return fmt.Errorf("username %s is %w", name, errUsernameInUse)
+	}
+
+	return nil
+}
 
-	msg := &packets.Message{}
+func (s *Server) handleConnection(sess *session) {
+	defer sess.conn.close()
 
 	for {
-		err := msg.Receive(conn)
-		if err != nil && err != io.EOF {
-			log.Printf("Failed to deserialize message from %s: %s", username, err)
-			continue
-		} else if err == io.EOF {
-			log.Printf("User %s connection closed by client", username)
-			s.removeConnection(username)
+		p, err := sess.conn.readPacket()
+		if err != nil {
+			var unknown *packets.UnknownTypeError
+			if errors.As(err, &unknown) {
+				logger.Warn("Ignoring packet", "user", sess.name, "err", err)
+				continue
+			}
+
+			if err == io.EOF {
+				logger.Info("Connection closed by client", "user", sess.name)
+			} else {
+				logger.Warn("Failed to deserialize packet", "user", sess.name, "err", err)
+			}
+			s.removeConnection(sess)
+			s.abortTransfers(sess)
+			s.pluginsOnDisconnect(sess.name)
 			return
 		}
 
-		if len(s.conns) == 1 {
+		logger.Debug("Received packet", "user", sess.name, "packet", p)
+		s.metrics.packetsReceived(p)
+		if p = s.pluginsBeforeRelay(sess, p); p == nil {
 			continue
 		}
-		var to []string
 
-		for u := range maps.Keys(s.conns) {
-			if u != username {
-				to = append(to, u)
-			}
+		switch p := p.(type) {
+		case *packets.Message:
+			s.relay(sess, p)
+		case *packets.Command:
+			s.handleCommand(sess, p)
+		case *packets.Typing:
+			s.relayTyping(sess, p)
+		case *packets.ReadReceipt:
+			s.markRead(sess, p)
+		case *packets.MessageEdit:
+			s.editMessage(sess, p)
+		case *packets.MessageDelete:
+			s.deleteMessage(sess, p)
+		case *packets.Reaction:
+			s.react(sess, p)
+		case *packets.SearchRequest:
+			s.search(sess, p)
+		case *packets.FileOffer:
+			s.offerFile(sess, p)
+		case *packets.FileAccept:
+			s.acceptFile(sess, p)
+		case *packets.FileChunk:
+			s.receiveChunk(sess, p)
+		case *packets.FileComplete:
+			s.completeFile(sess, p)
+		case *packets.FileCancel:
+			s.cancelFile(sess, p)
+		default:
+			logger.Warn("Unexpected packet", "user", sess.name, "packet", p)
 		}
+	}
+}
 
-		log.Printf("To: %v", to)
+// relay posts a message sent by a user.
+func (s *Server) relay(sess *session, msg *packets.Message) {
+	s.mu.Lock()
+	msg.From = sess.name
+	s.mu.Unlock()
 
-		s.multicast(msg, to)
+	if limit := s.settings().RateLimit; !sess.messages.allow(limit) {
+		s.notify(msg.From, fmt.Sprintf("Not sent: you can only send %d messages per minute", limit))
+		return
+	}
+
+	if err := s.post(msg); err != nil {
+		s.notify(msg.From, fmt.Sprintf("Cannot reply: %s", err))
 	}
 }
 
-func (s *Server) removeConnection(username string) {
+// post stores a message and forwards it to every user, including its sender
+// who learns the ID it was assigned. It fails if the message replies to an
+// unknown one.
+func (s *Server) post(msg *packets.Message) error {
+	msg.ServerTime = time.Now()
+
+	if msg.ParentID != 0 {
+		parent, err := s.history.get(msg.ParentID)
+		if err != nil {
+			return err
+		}
+
+		// Threads are one level deep, a reply to a reply joins its thread
+		if parent.ParentID != 0 {
+			msg.ParentID = parent.ParentID
+		}
+	}
+
+	if err := s.history.append(msg); err != nil {
+		logger.Error("Failed to store message", "user", msg.From, "err", err)
+	}
+
+	s.multicast(msg, s.onlineUsers())
+	s.pluginsAfterRelay(msg)
+	s.emitMessage(msg)
+	return nil
+}
+
+// markRead records how far a user has read and lets the other users know.
+func (s *Server) markRead(sess *session, receipt *packets.ReadReceipt) {
+	var to []string
+	receipt.Username, to = s.others(sess)
+
+	changed, err := s.history.markRead(receipt.Username, receipt.MessageID)
+	if err != nil {
+		logger.Error("Failed to store read position", "user", receipt.Username, "err", err)
+	}
+
+	if changed {
+		s.multicast(receipt, to)
+	}
+}
+
+// editMessage applies an edit made by the author of a message or a moderator
+// and forwards it to every user.
+func (s *Server) editMessage(sess *session, edit *packets.MessageEdit) {
+	s.mu.Lock()
+	edit.EditedBy = sess.name
+	s.mu.Unlock()
+
+	err := s.history.modify(edit.ID, func(m *packets.Message) error {
+		if err := s.checkModify(edit.EditedBy, m); err != nil {
+			return err
+		}
+
+		m.Payload = edit.Payload
+		m.Edited = true
+		return nil
+	})
+	if err != nil {
+		s.notify(edit.EditedBy, fmt.Sprintf("Cannot edit message: %s", err))
+		return
+	}
+
+	s.multicast(edit, s.onlineUsers())
+}
+
+// deleteMessage removes a message on behalf of its author or a moderator and
+// lets every user know.
+func (s *Server) deleteMessage(sess *session, del *packets.MessageDelete) {
+	s.mu.Lock()
+	del.DeletedBy = sess.name
+	s.mu.Unlock()
+
+	err := s.history.remove(del.ID, func(m *packets.Message) error {
+		return s.checkModify(del.DeletedBy, m)
+	})
+	if err != nil {
+		s.notify(del.DeletedBy, fmt.Sprintf("Cannot delete message: %s", err))
+		return
+	}
+
+	s.multicast(del, s.onlineUsers())
+}
+
+// react records a reaction and forwards the change to every user.
+func (s *Server) react(sess *session, reaction *packets.Reaction) {
+	s.mu.Lock()
+	reaction.Username = sess.name
+	s.mu.Unlock()
+
+	if reaction.Emoji == "" || len(reaction.Emoji) > maxEmojiLength || strings.ContainsFunc(reaction.Emoji, unicode.IsSpace) {
+		s.notify(reaction.Username, fmt.Sprintf("Cannot react with %q", reaction.Emoji))
+		return
+	}
+
+	changed, err := s.history.react(reaction.MessageID, reaction.Username, reaction.Emoji, reaction.Add)
+	if err != nil {
+		s.notify(reaction.Username, fmt.Sprintf("Cannot react: %s", err))
+		return
+	}
+
+	if changed {
+		s.multicast(reaction, s.onlineUsers())
+	}
+}
+
+// checkModify reports whether username may edit or delete m.
+func (s *Server) checkModify(username string, m *packets.Message) error {
+	if m.From != username && !s.isModerator(username) {
+		return errors.New("only its author or a moderator can change a message")
+	}
+	return nil
+}
+
+// relayTyping fans a typing signal out to everyone but its sender.
+func (s *Server) relayTyping(sess *session, typing *packets.Typing) {
+	var to []string
+	typing.Username, to = s.others(sess)
+
+	s.multicast(typing, to)
+}
+
+// others returns the current name of sess along with every other online user.
+func (s *Server) others(sess *session) (string, []string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	msg := &packets.Message{From: "CHAT", Payload: fmt.Sprintf("User %s has left the chat.", username), Timestamp: time.Now()}
-	// presence := &packets.Presence{Username: username, Status: true}
+	var to []string
+	for u := range maps.Keys(s.conns) {
+		if u != sess.name {
+			to = append(to, u)
+		}
+	}
+
+	return sess.name, to
+}
+
+func (s *Server) removeConnection(sess *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	delete(s.conns, username)
+	msg := &packets.Message{From: systemUser, Payload: fmt.Sprintf("User %s has left the chat.", sess.name), Timestamp: time.Now()}
+	presence := &packets.Presence{Username: sess.name, Status: false}
+
+	delete(s.conns, sess.name)
+	s.emit(&webhookEvent{Event: eventLeave, User: sess.name})
 	var to []string
 
 	for u := range maps.Keys(s.conns) {
 		to = append(to, u)
 	}
 
-	go s.multicast(msg, to)
-	// go s.multicast(presence, to)
+	go func() {
+		s.multicast(msg, to)
+		s.multicast(presence, to)
+	}()
 }
 
+// kick disconnects a user, letting everyone know who kicked them.
+func (s *Server) kick(username string, by string, reason string) error {
+	s.mu.Lock()
+	sess, ok := s.conns[username]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%s is not online", username)
+	}
+
+	why := ""
+	if reason != "" {
+		why = ": " + reason
+	}
+
+	logger.Info("User kicked", "user", username, "by", by, "reason", reason)
+	s.notify(username, fmt.Sprintf("You were kicked by %s%s", by, why))
+
+	others := slices.DeleteFunc(s.onlineUsers(), func(u string) bool { return u == username })
+	s.multicast(&packets.Message{From: systemUser, Payload: fmt.Sprintf("%s was kicked by %s%s", username, by, why), Timestamp: time.Now()}, others)
+
+	// The connection handler cleans the session up once reading fails
+	return sess.conn.close()
+}
+
+// multicast sends p to every user in to, carrying on past users that cannot
+// be reached.
 func (s *Server) multicast(p packets.Packet, to []string) error {
+	defer s.metrics.multicastDone(time.Now())
+
+	var errs []error
 	for _, username := range to {
 		if err := s.send(p, username); err != nil {
-			return errors.Join(errors.New("failed to multicast packet"), err)
+			errs = append(errs, err)
 		}
 	}
 
+	if len(errs) > 0 {
+		return errors.Join(append([]error{errors.New("failed to multicast packet")}, errs...)...)
+	}
+
 	return nil
 }
 
 func (s *Server) send(p packets.Packet, to string) error {
-	log.Printf("Sending %s to %s", p, to)
-	conn, ok := s.conns[to]
+	s.mu.Lock()
+	sess, ok := s.conns[to]
+	s.mu.Unlock()
 	if !ok {
 		return fmt.Errorf("no connection found for %s", to)
 	}
 
-	log.Printf("Sending %s to %s", p, to)
-
-	_, err := conn.Write(p.Encode())
-	if err != nil {
+	if err := sess.write(p); err != nil {
 		return errors.Join(fmt.Errorf("failed to send packet %s to %s", p, to), err)
 	}
 
-	log.Printf("Packet %s was sent to %s", p, to)
+	logger.Debug("Sent packet", "user", to, "packet", p)
 
 	return nil
 }
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/root-man/chat/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJoin_SlowClient(t *testing.T) {
+	s := newTestServer(t)
+	require.NoError(t, s.history.append(&packets.Message{From: "alice", Payload: "earlier"}))
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go client.Write(packets.Frame(&packets.Handshake{Username: "bob", Version: packets.ProtocolVersion}))
+	joined := make(chan error, 1)
+	go func() {
+		_, err := s.handshake(server)
+		joined <- err
+	}()
+
+	// bob does not read yet, which must not hold up everyone else
+	assert.Eventually(t, func() bool { return slices.Contains(s.onlineUsers(), "bob") }, time.Second, 10*time.Millisecond)
+	go s.multicast(&packets.Message{From: "alice", Payload: "live"}, []string{"bob"})
+
+	var received []packets.Packet
+	for {
+		p, err := packets.ReadFrame(client)
+		require.NoError(t, err)
+		received = append(received, p)
+		if m, ok := p.(*packets.Message); ok && m.Payload == "live" {
+			break
+		}
+	}
+	require.NoError(t, <-joined)
+
+	assert.IsType(t, &packets.HandshakeResponse{}, received[0])
+	assert.Equal(t, "earlier", received[1].(*packets.Message).Payload)
+	assert.Equal(t, &packets.ReadReceipt{Username: "bob"}, received[2], "the welcome comes before what was sent meanwhile")
+	assert.Len(t, received, 4)
+}
+
+func TestRun_SilentClient(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := newTestServer(t)
+	s.listener = l
+	go s.Run()
+	t.Cleanup(func() { s.Close() })
+
+	// A client that never sends its handshake must not hold up the others
+	silent, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer silent.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	_, err = conn.Write(packets.Frame(&packets.Handshake{Username: "bob", Version: packets.ProtocolVersion}))
+	require.NoError(t, err)
+	p, err := packets.ReadFrame(conn)
+	require.NoError(t, err)
+	assert.IsType(t, &packets.HandshakeResponse{}, p)
+}
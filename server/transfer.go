@@ -0,0 +1,446 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/root-man/chat/packets"
+)
+
+// defaultMaxFileSize is the size limit of transferred files when the
+// configuration does not say otherwise.
+const defaultMaxFileSize = 64 << 20
+
+// transfer is a file being uploaded by its sender. The server never holds the
+// file in memory: chunks are relayed to the users who accepted the offer as
+// they arrive, and written to disk when file storage is enabled so that users
+// can download the file later. Stored files loaded on start have no sender.
+type transfer struct {
+	offer  packets.FileOffer
+	sender *session
+
+	mu sync.Mutex
+	// acceptors receive the chunks relayed during the upload.
+	acceptors []*session
+	// waiting accepted a stored file after the upload started, they are
+	// served from disk once it is complete.
+	waiting  []*session
+	started  bool
+	received uint64
+	hash     hash.Hash
+	// path is where the file is stored, empty when storage is disabled.
+	path     string
+	file     *os.File
+	complete bool
+	// updated is when the upload last made progress.
+	updated time.Time
+}
+
+func (t *transfer) stored() bool {
+	return t.path != ""
+}
+
+// offerFile registers a file offered by a user and forwards the offer to its
+// recipients, echoing it back to the sender with the ID it was assigned.
+func (s *Server) offerFile(sess *session, offer *packets.FileOffer) {
+	s.mu.Lock()
+	offer.From = sess.name
+	to, err := s.recipients(sess.name, offer.To)
+	s.mu.Unlock()
+
+	if err == nil {
+		err = s.checkOffer(offer)
+	}
+	if err != nil {
+		s.notify(offer.From, fmt.Sprintf("Cannot send %s: %s", offer.Name, err))
+		return
+	}
+
+	t := &transfer{sender: sess, hash: sha256.New(), updated: time.Now()}
+	if dir := s.settings().FileDir; dir != "" {
+		if t.file, err = os.CreateTemp(dir, uploadPattern); err != nil {
+			transferLogger.Error("Failed to store file", "file", offer.Name, "user", offer.From, "err", err)
+			s.notify(offer.From, fmt.Sprintf("Cannot send %s: the server failed to store it", offer.Name))
+			return
+		}
+		t.path = t.file.Name()
+	}
+
+	s.mu.Lock()
+	evicted, err := s.checkLimits(offer.From, offer.Size, t.stored())
+	if err != nil {
+		s.mu.Unlock()
+		if t.file != nil {
+			t.file.Close()
+			os.Remove(t.path)
+		}
+		s.notify(offer.From, fmt.Sprintf("Cannot send %s: %s", offer.Name, err))
+		return
+	}
+	for _, old := range evicted {
+		delete(s.transfers, old.offer.ID)
+	}
+	s.nextTransferID++
+	offer.ID = s.nextTransferID
+	t.offer = *offer
+	s.transfers[offer.ID] = t
+	s.mu.Unlock()
+
+	for _, old := range evicted {
+		transferLogger.Info("Deleted stored file to make room", "offer", &old.offer)
+		removeStoredFile(old)
+	}
+
+	transferLogger.Info("User offered a file", "user", offer.From, "offer", offer)
+	s.send(offer, offer.From)
+	s.multicast(offer, to)
+
+	// A stored file is uploaded right away, the server accepting it itself
+	if t.stored() {
+		sess.write(&packets.FileAccept{ID: offer.ID, Username: systemUser})
+	}
+}
+
+// recipients returns the users a file offered by from to to is sent to. The
+// caller must hold s.mu.
+func (s *Server) recipients(from string, to string) ([]string, error) {
+	if to != "" {
+		if _, ok := s.conns[to]; !ok || to == from {
+			return nil, fmt.Errorf("%s is not online", to)
+		}
+		return []string{to}, nil
+	}
+
+	var users []string
+	for username := range s.conns {
+		if username != from {
+			users = append(users, username)
+		}
+	}
+	return users, nil
+}
+
+func (s *Server) checkOffer(offer *packets.FileOffer) error {
+	if offer.Name == "" || offer.Name != filepath.Base(offer.Name) || strings.ContainsAny(offer.Name, `/\`) || offer.Name == ".." {
+		return errors.New("invalid file name")
+	}
+
+	maxSize := s.settings().MaxFileSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxFileSize
+	}
+	if offer.Size > uint64(maxSize) {
+		return fmt.Errorf("files are limited to %d bytes", maxSize)
+	}
+
+	return nil
+}
+
+// acceptFile adds a user to the receivers of a transfer, serving them from
+// disk if the file is already stored.
+func (s *Server) acceptFile(sess *session, accept *packets.FileAccept) {
+	s.mu.Lock()
+	accept.Username = sess.name
+	t, ok := s.transfers[accept.ID]
+	s.mu.Unlock()
+
+	if !ok || t.sender == sess || (t.offer.To != "" && t.offer.To != accept.Username) {
+		s.cancelFor(sess, accept.ID, "no such file transfer")
+		return
+	}
+
+	t.mu.Lock()
+	switch {
+	case t.complete:
+		t.mu.Unlock()
+		go s.serveFile(t, sess)
+		return
+	case t.started && t.stored():
+		t.waiting = append(t.waiting, sess)
+		t.mu.Unlock()
+		return
+	case t.started:
+		t.mu.Unlock()
+		s.cancelFor(sess, accept.ID, "the upload already started")
+		return
+	}
+
+	if !slices.Contains(t.acceptors, sess) {
+		t.acceptors = append(t.acceptors, sess)
+	}
+	t.mu.Unlock()
+
+	if err := t.sender.write(accept); err != nil {
+		transferLogger.Warn("Failed to forward acceptance", "accept", accept, "err", err)
+	}
+}
+
+// receiveChunk stores and relays a chunk uploaded by the sender of a
+// transfer.
+func (s *Server) receiveChunk(sess *session, chunk *packets.FileChunk) {
+	t := s.ownTransfer(sess, chunk.ID)
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	if chunk.Offset != t.received || t.received+uint64(len(chunk.Data)) > t.offer.Size {
+		t.mu.Unlock()
+		s.abortTransfer(t, "the upload is corrupted")
+		return
+	}
+
+	if t.file != nil {
+		if _, err := t.file.Write(chunk.Data); err != nil {
+			t.mu.Unlock()
+			transferLogger.Error("Failed to store file", "offer", &t.offer, "err", err)
+			s.abortTransfer(t, "the server failed to store the file")
+			return
+		}
+	}
+
+	t.hash.Write(chunk.Data)
+	t.received += uint64(len(chunk.Data))
+	t.started = true
+	t.updated = time.Now()
+	acceptors := slices.Clone(t.acceptors)
+	t.mu.Unlock()
+
+	for _, a := range acceptors {
+		if err := a.write(chunk); err != nil {
+			t.drop(a)
+		}
+	}
+}
+
+// completeFile checks an upload against its offer and lets the receivers
+// know it is over.
+func (s *Server) completeFile(sess *session, complete *packets.FileComplete) {
+	t := s.ownTransfer(sess, complete.ID)
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	if t.received != t.offer.Size || !bytes.Equal(t.hash.Sum(nil), t.offer.Checksum[:]) {
+		t.mu.Unlock()
+		s.abortTransfer(t, "checksum mismatch")
+		return
+	}
+
+	if t.file != nil {
+		err := t.file.Close()
+		t.file = nil
+		if err == nil {
+			err = storeFile(t)
+		}
+		if err != nil {
+			t.mu.Unlock()
+			transferLogger.Error("Failed to store file", "offer", &t.offer, "err", err)
+			s.abortTransfer(t, "the server failed to store the file")
+			return
+		}
+	}
+
+	t.started = true
+	t.complete = true
+	acceptors, waiting := t.acceptors, t.waiting
+	t.acceptors, t.waiting = nil, nil
+	t.mu.Unlock()
+
+	transferLogger.Info("Upload complete", "offer", &t.offer)
+	for _, a := range acceptors {
+		a.write(complete)
+	}
+
+	for _, w := range waiting {
+		go s.serveFile(t, w)
+	}
+
+	if !t.stored() {
+		s.mu.Lock()
+		delete(s.transfers, t.offer.ID)
+		s.mu.Unlock()
+	}
+}
+
+// cancelFile aborts a transfer for everyone when its sender cancels it, or
+// stops delivering it to a receiver that gave up.
+func (s *Server) cancelFile(sess *session, cancel *packets.FileCancel) {
+	s.mu.Lock()
+	cancel.Username = sess.name
+	t, ok := s.transfers[cancel.ID]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if t.sender == sess {
+		s.abortTransfer(t, cancel.Reason)
+		return
+	}
+
+	t.drop(sess)
+
+	// Without storage, an upload nobody receives anymore is pointless
+	t.mu.Lock()
+	idle := t.started && !t.complete && !t.stored() && len(t.acceptors) == 0
+	t.mu.Unlock()
+	if idle {
+		s.abortTransfer(t, "every receiver cancelled")
+	}
+}
+
+// ownTransfer returns the unfinished transfer with the given ID if sess is
+// its sender. Packets for other transfers, such as the chunks in flight when
+// a transfer is aborted, are ignored.
+func (s *Server) ownTransfer(sess *session, id uint64) *transfer {
+	s.mu.Lock()
+	t, ok := s.transfers[id]
+	s.mu.Unlock()
+
+	if !ok || t.sender != sess {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.complete {
+		return nil
+	}
+
+	return t
+}
+
+// abortTransfer drops a transfer and its stored file, letting the sender and
+// every recipient know.
+func (s *Server) abortTransfer(t *transfer, reason string) {
+	s.mu.Lock()
+	if s.transfers[t.offer.ID] != t {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.transfers, t.offer.ID)
+	to, _ := s.recipients(t.offer.From, t.offer.To)
+	s.mu.Unlock()
+
+	t.mu.Lock()
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+	if t.stored() {
+		removeStoredFile(t)
+	}
+	t.mu.Unlock()
+
+	transferLogger.Info("Transfer aborted", "offer", &t.offer, "reason", reason)
+	cancel := &packets.FileCancel{ID: t.offer.ID, Username: t.offer.From, Reason: reason}
+	t.sender.write(cancel)
+	s.multicast(cancel, to)
+}
+
+// abortTransfers aborts the unfinished uploads of a user who left.
+func (s *Server) abortTransfers(sess *session) {
+	s.mu.Lock()
+	var unfinished []*transfer
+	for _, t := range s.transfers {
+		if t.sender == sess && !t.complete {
+			unfinished = append(unfinished, t)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, t := range unfinished {
+		s.abortTransfer(t, "the sender left")
+	}
+}
+
+// serveFile sends a stored file to a user, announcing it with its offer.
+func (s *Server) serveFile(t *transfer, sess *session) {
+	f, err := os.Open(t.path)
+	if err != nil {
+		transferLogger.Error("Failed to open stored file", "offer", &t.offer, "err", err)
+		s.cancelFor(sess, t.offer.ID, "the file is no longer available")
+		return
+	}
+	defer f.Close()
+
+	if err := sess.write(&t.offer); err != nil {
+		return
+	}
+
+	buf := make([]byte, packets.FileChunkSize)
+	var offset uint64
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if err := sess.write(&packets.FileChunk{ID: t.offer.ID, Offset: offset, Data: buf[:n]}); err != nil {
+				return
+			}
+			offset += uint64(n)
+		}
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			transferLogger.Error("Failed to read stored file", "offer", &t.offer, "err", err)
+			s.cancelFor(sess, t.offer.ID, "the server failed to read the file")
+			return
+		}
+	}
+
+	sess.write(&packets.FileComplete{ID: t.offer.ID})
+}
+
+// cancelFor tells a single user that a transfer is cancelled for them.
+func (s *Server) cancelFor(sess *session, id uint64, reason string) {
+	sess.write(&packets.FileCancel{ID: id, Username: systemUser, Reason: reason})
+}
+
+// drop removes a user from the receivers of a transfer.
+func (t *transfer) drop(sess *session) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.acceptors = slices.DeleteFunc(t.acceptors, func(a *session) bool { return a == sess })
+	t.waiting = slices.DeleteFunc(t.waiting, func(w *session) bool { return w == sess })
+}
+
+// cmdFiles lists the files stored on the server.
+func (s *Server) cmdFiles(sess *session, _ string) error {
+	s.mu.Lock()
+	var lines []string
+	for _, id := range slices.Sorted(maps.Keys(s.transfers)) {
+		t := s.transfers[id]
+		t.mu.Lock()
+		if t.complete && (t.offer.To == "" || t.offer.To == sess.name || t.offer.From == sess.name) {
+			lines = append(lines, fmt.Sprintf("#%d %s (%d bytes) from %s", id, t.offer.Name, t.offer.Size, t.offer.From))
+		}
+		t.mu.Unlock()
+	}
+	username := sess.name
+	s.mu.Unlock()
+
+	if len(lines) == 0 {
+		s.notify(username, "No files are stored on the server")
+		return nil
+	}
+
+	s.notify(username, "Stored files, type /accept <id> to download one:")
+	for _, line := range lines {
+		s.notify(username, line)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"crypto/sha256"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/root-man/chat/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// connect adds a session to s and returns the packets the server sends it.
+func connect(t *testing.T, s *Server, name string) (*session, <-chan packets.Packet) {
+	server, client := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+
+	received := make(chan packets.Packet, 100)
+	go func() {
+		for {
+			p, err := packets.ReadFrame(client)
+			if err != nil {
+				return
+			}
+			received <- p
+		}
+	}()
+
+	sess := &session{name: name, conn: newTCPTransport(server)}
+	s.conns[name] = sess
+	return sess, received
+}
+
+// newTestServer returns a server without listeners, holding the default room
+// and a history kept in memory.
+func newTestServer(t *testing.T) *Server {
+	history, err := openHistory("", 0)
+	require.NoError(t, err)
+
+	s := &Server{conns: make(map[string]*session), rooms: map[string]*room{defaultRoom: {name: defaultRoom}}, history: history, transfers: make(map[uint64]*transfer)}
+	s.config.Store(&Config{})
+	return s
+}
+
+func TestTransfer(t *testing.T) {
+	data := []byte("a file that is not too long")
+	offer := func() *packets.FileOffer {
+		return &packets.FileOffer{To: "bob", Name: "notes.txt", Size: uint64(len(data)), Checksum: sha256.Sum256(data)}
+	}
+
+	t.Run("relayed", func(t *testing.T) {
+		s := newTestServer(t)
+		alice, fromServer := connect(t, s, "alice")
+		bob, toBob := connect(t, s, "bob")
+
+		s.offerFile(alice, offer())
+		echo := (<-fromServer).(*packets.FileOffer)
+		assert.Equal(t, uint64(1), echo.ID)
+		assert.Equal(t, "alice", (<-toBob).(*packets.FileOffer).From)
+
+		s.acceptFile(bob, &packets.FileAccept{ID: echo.ID})
+		assert.Equal(t, &packets.FileAccept{ID: 1, Username: "bob"}, <-fromServer)
+
+		s.receiveChunk(alice, &packets.FileChunk{ID: 1, Data: data[:10]})
+		s.receiveChunk(alice, &packets.FileChunk{ID: 1, Offset: 10, Data: data[10:]})
+		s.completeFile(alice, &packets.FileComplete{ID: 1})
+
+		assert.Equal(t, data[:10], (<-toBob).(*packets.FileChunk).Data)
+		assert.Equal(t, uint64(10), (<-toBob).(*packets.FileChunk).Offset)
+		assert.Equal(t, &packets.FileComplete{ID: 1}, <-toBob)
+		assert.Empty(t, s.transfers, "relayed transfers are forgotten once complete")
+	})
+
+	t.Run("corrupted", func(t *testing.T) {
+		s := newTestServer(t)
+		alice, fromServer := connect(t, s, "alice")
+		bob, toBob := connect(t, s, "bob")
+
+		s.offerFile(alice, offer())
+		<-fromServer
+		<-toBob
+		s.acceptFile(bob, &packets.FileAccept{ID: 1})
+		<-fromServer
+
+		s.receiveChunk(alice, &packets.FileChunk{ID: 1, Data: []byte("a file that is too long!!!!")})
+		s.completeFile(alice, &packets.FileComplete{ID: 1})
+
+		<-toBob
+		assert.Equal(t, "checksum mismatch", (<-toBob).(*packets.FileCancel).Reason)
+		assert.Equal(t, "checksum mismatch", (<-fromServer).(*packets.FileCancel).Reason)
+	})
+
+	t.Run("stored", func(t *testing.T) {
+		s := newTestServer(t)
+		s.config.Store(&Config{FileDir: t.TempDir()})
+		alice, fromServer := connect(t, s, "alice")
+		bob, toBob := connect(t, s, "bob")
+
+		s.offerFile(alice, offer())
+		<-fromServer
+		<-toBob
+		assert.Equal(t, &packets.FileAccept{ID: 1, Username: systemUser}, <-fromServer, "the server accepts stored files itself")
+
+		s.receiveChunk(alice, &packets.FileChunk{ID: 1, Data: data})
+		s.completeFile(alice, &packets.FileComplete{ID: 1})
+
+		s.acceptFile(bob, &packets.FileAccept{ID: 1})
+		assert.Equal(t, "notes.txt", (<-toBob).(*packets.FileOffer).Name)
+		assert.Equal(t, data, (<-toBob).(*packets.FileChunk).Data)
+		assert.Equal(t, &packets.FileComplete{ID: 1}, <-toBob)
+
+		// Another server finds the file again
+		transfers, lastID, err := loadStoredFiles(s.settings().FileDir)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), lastID)
+		require.Contains(t, transfers, uint64(1))
+		assert.True(t, transfers[1].complete)
+		assert.Equal(t, s.transfers[1].offer, transfers[1].offer)
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		dir := t.TempDir()
+		s := newTestServer(t)
+		s.config.Store(&Config{FileDir: dir, UserFileQuota: int64(2*len(data) + maxUnfinishedOffers)})
+		alice, fromServer := connect(t, s, "alice")
+		connect(t, s, "bob")
+		upload := func(id uint64) {
+			s.offerFile(alice, offer())
+			<-fromServer
+			<-fromServer
+			s.receiveChunk(alice, &packets.FileChunk{ID: id, Data: data})
+			s.completeFile(alice, &packets.FileComplete{ID: id})
+		}
+
+		upload(1)
+		upload(2)
+		upload(3)
+		assert.NotContains(t, s.transfers, uint64(1), "the oldest file makes room")
+		assert.NoFileExists(t, storedFilePath(dir, 1))
+		assert.FileExists(t, storedFilePath(dir, 3))
+
+		for range maxUnfinishedOffers {
+			s.offerFile(alice, &packets.FileOffer{Name: "notes.txt", Size: 1})
+			<-fromServer
+			<-fromServer
+		}
+		s.offerFile(alice, &packets.FileOffer{Name: "notes.txt", Size: 1})
+		assert.Equal(t, "Cannot send notes.txt: you can only send 3 files at once", (<-fromServer).(*packets.Message).Payload)
+
+		s.expireTransfers(time.Now().Add(time.Minute))
+		assert.Equal(t, "the upload expired", (<-fromServer).(*packets.FileCancel).Reason)
+		assert.Len(t, s.transfers, 2, "only the stored files are left")
+
+		// Leftovers of the interrupted uploads are cleaned up on start
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "transfer-1234"), data, 0o600))
+		require.NoError(t, os.WriteFile(storedFilePath(dir, 9), data, 0o600))
+		transfers, lastID, err := loadStoredFiles(dir)
+		require.NoError(t, err)
+		assert.Len(t, transfers, 2)
+		assert.Equal(t, uint64(3), lastID)
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Len(t, entries, 4)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		s := newTestServer(t)
+		s.config.Store(&Config{MaxFileSize: 8})
+		alice, fromServer := connect(t, s, "alice")
+		connect(t, s, "bob")
+
+		s.offerFile(alice, offer())
+		require.IsType(t, &packets.Message{}, <-fromServer)
+		assert.Empty(t, s.transfers)
+	})
+}
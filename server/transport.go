@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net"
+
+	"github.com/root-man/chat/packets"
+)
+
+// transport carries the packets of a session, whichever protocol the user
+// connected with.
+type transport interface {
+	readPacket() (packets.Packet, error)
+	// writePackets sends packets at once, which lets the transport compress
+	// them together.
+	writePackets(ps ...packets.Packet) error
+	close() error
+	remoteAddr() net.Addr
+}
+
+// tcpTransport speaks the binary protocol of the packets package, used by
+// the terminal client.
+type tcpTransport struct {
+	conn   net.Conn
+	reader *packets.Reader
+	// compress is set when the client negotiated compressed frames.
+	compress bool
+}
+
+func newTCPTransport(conn net.Conn) *tcpTransport {
+	return &tcpTransport{conn: conn, reader: packets.NewReader(conn)}
+}
+
+func (t *tcpTransport) readPacket() (packets.Packet, error) {
+	return t.reader.ReadPacket()
+}
+
+func (t *tcpTransport) writePackets(ps ...packets.Packet) error {
+	var frames []byte
+	for _, p := range ps {
+		frames = append(frames, packets.Frame(p)...)
+	}
+
+	if t.compress {
+		frames = packets.Compress(frames)
+	}
+
+	_, err := t.conn.Write(frames)
+	return err
+}
+
+func (t *tcpTransport) close() error {
+	return t.conn.Close()
+}
+
+func (t *tcpTransport) remoteAddr() net.Addr {
+	return t.conn.RemoteAddr()
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/root-man/chat/packets"
+)
+
+const (
+	unfurlTimeout = 5 * time.Second
+	// maxUnfurlSize is how much of a page is read looking for its title.
+	maxUnfurlSize = 256 << 10
+	// maxTitleLength keeps the titles of pages to a line.
+	maxTitleLength = 120
+)
+
+var (
+	linkPattern  = regexp.MustCompile(`https?://[^\s<>()\[\]]+`)
+	titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+// unfurlOptions configure the URL unfurling plugin.
+type unfurlOptions struct {
+	// MaxLinks is the number of links unfurled per message.
+	MaxLinks int `json:"max-links"`
+	// AllowPrivate lets the server fetch pages from loopback and private
+	// addresses, which it refuses by default so that users cannot probe the
+	// network of the server.
+	AllowPrivate bool `json:"allow-private"`
+}
+
+// newURLUnfurler creates a plugin that posts the title of the pages linked to
+// in messages.
+func newURLUnfurler(options json.RawMessage) (*Plugin, error) {
+	opts := unfurlOptions{MaxLinks: 3}
+	if err := decodeOptions(options, &opts); err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: unfurlTimeout}
+	if !opts.AllowPrivate {
+		dialer := &net.Dialer{Timeout: unfurlTimeout, Control: refusePrivate}
+		client.Transport = &http.Transport{DialContext: dialer.DialContext}
+	}
+
+	return &Plugin{AfterRelay: func(e *Event) {
+		msg := e.Packet.(*packets.Message)
+		var links []string
+		for _, link := range linkPattern.FindAllString(msg.Payload, -1) {
+			link = strings.TrimRight(link, ".,;:!?'\"")
+			if !slices.Contains(links, link) && len(links) < opts.MaxLinks {
+				links = append(links, link)
+			}
+		}
+
+		if len(links) == 0 {
+			return
+		}
+
+		// Pages are fetched in the background not to hold the sender up
+		go func() {
+			for _, link := range links {
+				title, err := fetchTitle(client, link)
+				if err != nil {
+					pluginLogger.Info("Failed to unfurl link", "url", link, "err", err)
+					continue
+				}
+				e.Emit(&packets.Message{From: systemUser, Payload: fmt.Sprintf("🔗 %s: %s", link, title), Timestamp: time.Now()})
+			}
+		}()
+	}}, nil
+}
+
+// fetchTitle returns the title of an HTML page.
+func fetchTitle(client *http.Client, link string) (string, error) {
+	resp, err := client.Get(link)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("got status %s", resp.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" {
+		return "", fmt.Errorf("not a web page but %q", mediaType)
+	}
+
+	page, err := io.ReadAll(io.LimitReader(resp.Body, maxUnfurlSize))
+	if err != nil {
+		return "", err
+	}
+
+	match := titlePattern.FindSubmatch(page)
+	if match == nil {
+		return "", errors.New("the page has no title")
+	}
+
+	title := strings.Join(strings.Fields(html.UnescapeString(string(match[1]))), " ")
+	if runes := []rune(title); len(runes) > maxTitleLength {
+		title = string(runes[:maxTitleLength-1]) + "…"
+	}
+	if title == "" {
+		return "", errors.New("the page has no title")
+	}
+	return title, nil
+}
+
+// refusePrivate is a dialer control refusing the addresses that are not
+// public, including after redirects.
+func refusePrivate(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return fmt.Errorf("refusing to fetch the private address %s", ip)
+	}
+	return nil
+}
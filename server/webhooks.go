@@ -0,0 +1,216 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/root-man/chat/packets"
+)
+
+// The events webhooks can subscribe to.
+const (
+	eventMessage = "message"
+	eventJoin    = "join"
+	eventLeave   = "leave"
+	eventMention = "mention"
+	eventCommand = "command"
+)
+
+var webhookEvents = []string{eventMessage, eventJoin, eventLeave, eventMention, eventCommand}
+
+const (
+	// webhookQueueSize is the number of events waiting to be delivered to a
+	// webhook, newer events being dropped past it.
+	webhookQueueSize = 256
+	// webhookAttempts is how many times the delivery of an event is tried.
+	webhookAttempts = 5
+	webhookTimeout  = 10 * time.Second
+	// webhookSignatureHeader holds the hex HMAC-SHA256 of the body, keyed with
+	// the secret of the webhook.
+	webhookSignatureHeader = "X-Chat-Signature"
+	webhookEventHeader     = "X-Chat-Event"
+)
+
+// Webhook is an URL the server posts chat events to.
+type Webhook struct {
+	URL string `json:"url"`
+	// Secret signs the events so that the receiver can check where they come
+	// from.
+	Secret string `json:"secret"`
+	// Events are the events posted, all of them when it is empty.
+	Events []string `json:"events"`
+}
+
+func (w *Webhook) validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q", w.URL)
+	}
+	for _, event := range w.Events {
+		if !slices.Contains(webhookEvents, event) {
+			return fmt.Errorf("unknown webhook event %q, expected one of %v", event, webhookEvents)
+		}
+	}
+	return nil
+}
+
+func (w *Webhook) wants(event string) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, event)
+}
+
+// webhookEvent is the JSON body posted to webhooks.
+type webhookEvent struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	// User is who joined, left, was mentioned or ran the command.
+	User    string      `json:"user,omitempty"`
+	Message *apiMessage `json:"message,omitempty"`
+	Command string      `json:"command,omitempty"`
+	Args    string      `json:"args,omitempty"`
+}
+
+// webhookSender delivers the events of a webhook one after the other, in the
+// background.
+type webhookSender struct {
+	Webhook
+	queue  chan *webhookEvent
+	client *http.Client
+	// backoff is the delay before the first retry, doubling with each one.
+	backoff time.Duration
+}
+
+// startWebhooks starts delivering events to every webhook.
+func startWebhooks(hooks []Webhook) []*webhookSender {
+	var senders []*webhookSender
+	for _, hook := range hooks {
+		sender := &webhookSender{Webhook: hook, queue: make(chan *webhookEvent, webhookQueueSize), client: &http.Client{Timeout: webhookTimeout}, backoff: time.Second}
+		go sender.run()
+		senders = append(senders, sender)
+	}
+	return senders
+}
+
+// replaceWebhooks starts delivering events to the webhooks of a new
+// configuration, keeping the queue of those that did not change. The events
+// queued for the removed ones are still delivered.
+func (s *Server) replaceWebhooks(hooks []Webhook) {
+	s.hooksMu.Lock()
+	defer s.hooksMu.Unlock()
+
+	removed := slices.Clone(s.webhooks)
+	var senders []*webhookSender
+	for _, hook := range hooks {
+		i := slices.IndexFunc(removed, func(sender *webhookSender) bool { return reflect.DeepEqual(sender.Webhook, hook) })
+		if i < 0 {
+			senders = append(senders, startWebhooks([]Webhook{hook})...)
+			continue
+		}
+		senders = append(senders, removed[i])
+		removed = slices.Delete(removed, i, i+1)
+	}
+
+	for _, sender := range removed {
+		close(sender.queue)
+	}
+	s.webhooks = senders
+}
+
+// emit queues an event for the webhooks subscribed to it.
+func (s *Server) emit(event *webhookEvent) {
+	event.Time = time.Now()
+
+	s.hooksMu.RLock()
+	defer s.hooksMu.RUnlock()
+	for _, sender := range s.webhooks {
+		if !sender.wants(event.Event) {
+			continue
+		}
+
+		select {
+		case sender.queue <- event:
+		default:
+			webhookLogger.Warn("Dropping event, the queue is full", "event", event.Event, "url", sender.URL)
+		}
+	}
+}
+
+// emitMessage emits a message along with the mentions it contains.
+func (s *Server) emitMessage(msg *packets.Message) {
+	m := newAPIMessage(msg)
+	s.emit(&webhookEvent{Event: eventMessage, User: msg.From, Message: &m})
+	for _, username := range packets.Mentions(msg.Payload) {
+		s.emit(&webhookEvent{Event: eventMention, User: username, Message: &m})
+	}
+}
+
+func (w *webhookSender) run() {
+	for event := range w.queue {
+		body, err := json.Marshal(event)
+		if err != nil {
+			webhookLogger.Error("Failed to encode event", "event", event.Event, "err", err)
+			continue
+		}
+
+		backoff := w.backoff
+		for attempt := 1; ; attempt++ {
+			retry, err := w.deliver(event.Event, body)
+			if err == nil {
+				break
+			}
+			if !retry || attempt == webhookAttempts {
+				webhookLogger.Error("Failed to deliver event", "event", event.Event, "url", w.URL, "attempts", attempt, "err", err)
+				break
+			}
+
+			webhookLogger.Warn("Failed to deliver event, retrying", "event", event.Event, "url", w.URL, "delay", backoff, "err", err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+// deliver posts an event once, telling whether it is worth retrying if it
+// fails.
+func (w *webhookSender) deliver(event string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, event)
+	if w.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, "sha256="+signWebhook(w.Secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("got status %s", resp.Status)
+	default:
+		return false, fmt.Errorf("got status %s", resp.Status)
+	}
+}
+
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/root-man/chat/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhooks(t *testing.T) {
+	deliveries := make(chan webhookEvent, 10)
+	failures := 2
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first deliveries fail to exercise the retries
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		var event webhookEvent
+		require.NoError(t, json.Unmarshal(body, &event))
+		assert.Equal(t, event.Event, r.Header.Get(webhookEventHeader))
+		assert.Equal(t, "sha256="+signWebhook("secret", body), r.Header.Get(webhookSignatureHeader))
+		deliveries <- event
+	}))
+	defer receiver.Close()
+
+	s := newTestServer(t)
+	s.webhooks = startWebhooks([]Webhook{{URL: receiver.URL, Secret: "secret", Events: []string{eventMessage, eventMention, eventCommand}}})
+	s.webhooks[0].backoff = time.Millisecond
+	alice, _ := connect(t, s, "alice")
+
+	next := func() webhookEvent {
+		select {
+		case event := <-deliveries:
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for a webhook event")
+			return webhookEvent{}
+		}
+	}
+
+	s.announce(alice)
+	s.relay(alice, &packets.Message{Payload: "ping @bob"})
+	event := next()
+	assert.Equal(t, eventMessage, event.Event, "join events are filtered out")
+	assert.Equal(t, "alice", event.User)
+	assert.Equal(t, "ping @bob", event.Message.Text)
+
+	event = next()
+	assert.Equal(t, eventMention, event.Event)
+	assert.Equal(t, "bob", event.User)
+
+	s.handleCommand(alice, &packets.Command{Name: "deploy", Args: "v1.2"})
+	event = next()
+	assert.Equal(t, eventCommand, event.Event)
+	assert.Equal(t, "deploy", event.Command)
+	assert.Equal(t, "v1.2", event.Args)
+}
+
+func TestWebhookValidation(t *testing.T) {
+	assert.NoError(t, (&Webhook{URL: "https://example.com/hook"}).validate())
+	assert.Error(t, (&Webhook{URL: "example.com/hook"}).validate())
+	assert.Error(t, (&Webhook{URL: "https://example.com/hook", Events: []string{"typing"}}).validate())
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/root-man/chat/packets"
+)
+
+const (
+	// wsPingPeriod is how often browsers are pinged so that dead connections
+	// are noticed, wsPongWait how long the server waits for an answer.
+	wsPingPeriod = 30 * time.Second
+	wsPongWait   = 2 * wsPingPeriod
+	wsWriteWait  = 10 * time.Second
+)
+
+// webPage is a minimal browser client, served by the gateway for testing.
+//
+//go:embed web/index.html
+var webPage []byte
+
+var upgrader = websocket.Upgrader{EnableCompression: true}
+
+// wsTransport carries packets encoded with packets.MarshalJSON over a
+// WebSocket, one per text message.
+type wsTransport struct {
+	conn *websocket.Conn
+	// mu serializes writes, which gorilla/websocket requires.
+	mu        sync.Mutex
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newWSTransport(conn *websocket.Conn) *wsTransport {
+	t := &wsTransport{conn: conn, done: make(chan struct{})}
+
+	// File chunks are base64 encoded in JSON, which takes more room
+	conn.SetReadLimit(2 * packets.MaxFrameSize)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	go t.ping()
+	return t
+}
+
+func (t *wsTransport) readPacket() (packets.Packet, error) {
+	_, data, err := t.conn.ReadMessage()
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, err
+	}
+
+	return packets.UnmarshalJSON(data)
+}
+
+func (t *wsTransport) writePackets(ps ...packets.Packet) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, p := range ps {
+		data, err := packets.MarshalJSON(p)
+		if err != nil {
+			return err
+		}
+
+		t.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		if err := t.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *wsTransport) close() error {
+	t.closeOnce.Do(func() { close(t.done) })
+	return t.conn.Close()
+}
+
+func (t *wsTransport) remoteAddr() net.Addr {
+	return t.conn.RemoteAddr()
+}
+
+// closeWith closes the connection, telling the browser why.
+func (t *wsTransport) closeWith(code int, reason string) {
+	// Control frames are limited to 125 bytes, two of them for the code
+	if len(reason) > 123 {
+		reason = reason[:120] + "..."
+	}
+
+	t.mu.Lock()
+	t.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteWait))
+	t.mu.Unlock()
+	t.close()
+}
+
+func (t *wsTransport) ping() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+			t.mu.Lock()
+			err := t.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+			t.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// serveWeb runs the gateway letting browsers join the chat over WebSocket.
+func (s *Server) serveWeb() {
+	server := &http.Server{Handler: s.webHandler(), ReadHeaderTimeout: 10 * time.Second}
+	if err := server.Serve(s.web); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		wsLogger.Error("WebSocket gateway stopped", "err", err)
+	}
+}
+
+func (s *Server) webHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(webPage)
+	})
+	mux.HandleFunc("GET /ws", s.serveWebSocket)
+	return mux
+}
+
+// serveWebSocket bridges a browser to the chat. It shares the sessions of the
+// terminal clients, only the encoding of the packets differs.
+func (s *Server) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already answered with an error
+		wsLogger.Warn("Upgrade failed", "remote", r.RemoteAddr, "err", err)
+		return
+	}
+
+	wsLogger.Info("Got incoming connection, initiating handshake", "remote", r.RemoteAddr)
+	t := newWSTransport(conn)
+	sess, err := s.wsHandshake(t)
+	if err != nil {
+		wsLogger.Warn("Handshake failed", "remote", r.RemoteAddr, "err", err)
+		t.closeWith(websocket.ClosePolicyViolation, err.Error())
+		return
+	}
+
+	s.announce(sess)
+	s.handleConnection(sess)
+}
+
+func (s *Server) wsHandshake(t *wsTransport) (*session, error) {
+	p, err := t.readPacket()
+	if err != nil {
+		s.metrics.handshakeFailed(handshakeMalformed)
+		return nil, err
+	}
+
+	handshake, ok := p.(*packets.Handshake)
+	if !ok {
+		s.metrics.handshakeFailed(handshakeMalformed)
+		return nil, fmt.Errorf("expected a handshake, got %s", p)
+	}
+
+	return s.join(t, handshake, func(response *packets.HandshakeResponse) error {
+		return t.writePackets(response)
+	})
+}
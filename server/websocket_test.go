@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/root-man/chat/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebSocketGateway(t *testing.T) {
+	s := newTestServer(t)
+	alice, toAlice := connect(t, s, "alice")
+
+	web := httptest.NewServer(s.webHandler())
+	defer web.Close()
+
+	resp, err := http.Get(web.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	browser, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(web.URL, "http")+"/ws", nil)
+	require.NoError(t, err)
+	defer browser.Close()
+
+	write := func(p packets.Packet) {
+		data, err := packets.MarshalJSON(p)
+		require.NoError(t, err)
+		require.NoError(t, browser.WriteMessage(websocket.TextMessage, data))
+	}
+	read := func() packets.Packet {
+		_, data, err := browser.ReadMessage()
+		require.NoError(t, err)
+		p, err := packets.UnmarshalJSON(data)
+		require.NoError(t, err)
+		return p
+	}
+
+	write(&packets.Handshake{Username: "bob", Version: packets.ProtocolVersion})
+	response := read().(*packets.HandshakeResponse)
+	assert.Equal(t, []string{"alice"}, response.OnlineUsers)
+	assert.Equal(t, &packets.ReadReceipt{Username: "bob"}, read(), "the welcome ends with the read position")
+
+	for _, received := range []packets.Packet{<-toAlice, read()} {
+		assert.Equal(t, "User bob has joined the chat!", received.(*packets.Message).Payload)
+	}
+	assert.Equal(t, &packets.Presence{Username: "bob", Status: true}, <-toAlice)
+	assert.IsType(t, &packets.Presence{}, read())
+
+	write(&packets.Message{Payload: "hello from a browser"})
+	fromBob := (<-toAlice).(*packets.Message)
+	assert.Equal(t, "bob", fromBob.From)
+	assert.Equal(t, "hello from a browser", fromBob.Payload)
+	echo := read().(*packets.Message)
+	assert.Equal(t, fromBob.ID, echo.ID, "messages are echoed to their sender")
+	assert.True(t, fromBob.ServerTime.Equal(echo.ServerTime))
+
+	s.relay(alice, &packets.Message{Payload: "hello from a terminal"})
+	assert.Equal(t, "hello from a terminal", read().(*packets.Message).Payload)
+	<-toAlice
+
+	write(&packets.Command{Name: "who"})
+	assert.Equal(t, "2 users online: alice, bob", read().(*packets.Message).Payload)
+
+	assert.Len(t, s.history.recent(10), 2, "browser and terminal users share the history")
+}